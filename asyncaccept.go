@@ -0,0 +1,100 @@
+package selfFastHttp
+
+import (
+	"net"
+	"sync"
+)
+
+// 异步接受连接:每个新连接在单独的协程中预处理(如读取PROXY protocol头、探测tls)，完成后才由Accept返回
+// 慢连接不阻塞Accept
+type asyncAcceptor struct {
+	ln net.Listener
+
+	// 预处理连接，在单独的协程中调用;返回错误时，关闭连接
+	prepare func(c net.Conn) (net.Conn, error)
+
+	// 返回true时，不预处理，直接交给Accept;可为nil
+	skip func(c net.Conn) bool
+
+	startOnce sync.Once
+	connCh    chan net.Conn
+	errCh     chan error    // 临时错误
+	doneCh    chan struct{} // 关闭:ln返回永久错误
+	err       error         // ln返回的永久错误
+
+	closeOnce sync.Once
+	closeCh   chan struct{}
+}
+
+func (a *asyncAcceptor) init() {
+	a.connCh = make(chan net.Conn)
+	a.errCh = make(chan error)
+	a.doneCh = make(chan struct{})
+	a.closeCh = make(chan struct{})
+	go a.acceptLoop()
+}
+
+// 返回已预处理的连接
+func (a *asyncAcceptor) Accept() (net.Conn, error) {
+	a.startOnce.Do(a.init)
+	select {
+	case c := <-a.connCh:
+		return c, nil
+	case err := <-a.errCh:
+		return nil, err
+	case <-a.doneCh:
+		return nil, a.err
+	case <-a.closeCh:
+		return nil, net.ErrClosed
+	}
+}
+
+func (a *asyncAcceptor) Close() error {
+	a.startOnce.Do(a.init)
+	a.closeOnce.Do(func() {
+		close(a.closeCh)
+	})
+	return a.ln.Close()
+}
+
+func (a *asyncAcceptor) acceptLoop() {
+	for {
+		c, err := a.ln.Accept()
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() { // 临时错误，交给调用者处理
+				select {
+				case a.errCh <- err:
+					continue
+				case <-a.closeCh:
+					return
+				}
+			}
+			a.err = err
+			close(a.doneCh)
+			return
+		}
+		if a.skip != nil && a.skip(c) {
+			a.deliver(c)
+			continue
+		}
+		go a.prepareConn(c)
+	}
+}
+
+func (a *asyncAcceptor) prepareConn(c net.Conn) {
+	pc, err := a.prepare(c)
+	if err != nil {
+		c.Close()
+		return
+	}
+	a.deliver(pc)
+}
+
+// 将连接交给Accept;已关闭时，关闭连接
+func (a *asyncAcceptor) deliver(c net.Conn) {
+	select {
+	case a.connCh <- c:
+	case <-a.closeCh:
+		c.Close()
+	}
+}
@@ -0,0 +1,166 @@
+package selfFastHttp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// 证书热更新:定时检测证书文件，变化后重新加载，无需重启服务
+// 使用:
+//
+//	cr, err := NewCertReloader(certFile, keyFile, time.Minute)
+//	s.TLSConfig = &tls.Config{GetCertificate: cr.GetCertificate}
+//	s.ListenAndServeTLS(addr, "", "")
+//
+// 加载失败(如证书、私钥未同时更新完)时，继续使用旧证书，并在下次检测时重试
+type CertReloader struct {
+	// 加载失败时的日志
+	// 默认使用log包
+	Logger Logger
+
+	certFile string
+	keyFile  string
+
+	lock     sync.RWMutex
+	cert     *tls.Certificate
+	certStat certFileStat // 已加载的证书文件状态
+	keyStat  certFileStat // 已加载的私钥文件状态
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+}
+
+// 文件状态:修改时间、大小任一变化，视为文件已变化
+type certFileStat struct {
+	modTime time.Time
+	size    int64
+}
+
+// 默认检测间隔
+const DefaultCertCheckInterval = 10 * time.Second
+
+// 加载证书，并每checkInterval检测一次文件变化
+// checkInterval<=0时，使用DefaultCertCheckInterval
+// 不再使用时，须调用Stop
+func NewCertReloader(certFile, keyFile string, checkInterval time.Duration) (*CertReloader, error) {
+	cr := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		stopCh:   make(chan struct{}),
+	}
+	if err := cr.Reload(); err != nil {
+		return nil, err
+	}
+	if checkInterval <= 0 {
+		checkInterval = DefaultCertCheckInterval
+	}
+	go cr.watch(checkInterval)
+	return cr, nil
+}
+
+// 用于tls.Config.GetCertificate
+// 客户端的SNI与证书不匹配时返回nil,tls随后从Certificates(含Server.AppendCert追加的)中按SNI选择;
+// 未设置其它证书时，该客户端握手失败
+// 无SNI(如按ip访问)时，返回已加载的证书
+func (cr *CertReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.lock.RLock()
+	cert := cr.cert
+	cr.lock.RUnlock()
+	if hello != nil && len(hello.ServerName) > 0 && cert.Leaf.VerifyHostname(hello.ServerName) != nil {
+		return nil, nil
+	}
+	return cert, nil
+}
+
+// 重新加载证书
+// 失败时，保留旧证书
+func (cr *CertReloader) Reload() error {
+	certStat, err := statCertFile(cr.certFile)
+	if err != nil {
+		return err
+	}
+	keyStat, err := statCertFile(cr.keyFile)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return fmt.Errorf("cannot load TLS key pair from certFile=%q and keyFile=%q: %s", cr.certFile, cr.keyFile, err)
+	}
+	if cert.Leaf == nil { // 按SNI匹配时使用
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return fmt.Errorf("cannot parse TLS certificate from certFile=%q: %s", cr.certFile, err)
+		}
+	}
+
+	cr.lock.Lock()
+	cr.cert = &cert
+	cr.certStat = certStat
+	cr.keyStat = keyStat
+	cr.lock.Unlock()
+	return nil
+}
+
+// 停止检测文件变化
+// 已加载的证书仍可使用
+func (cr *CertReloader) Stop() {
+	cr.stopOnce.Do(func() {
+		close(cr.stopCh)
+	})
+}
+
+func (cr *CertReloader) watch(checkInterval time.Duration) {
+	t := time.NewTicker(checkInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-cr.stopCh:
+			return
+		case <-t.C:
+		}
+		if !cr.changed() {
+			continue
+		}
+		if err := cr.Reload(); err != nil {
+			cr.logger().Printf("cannot reload TLS certificate: %s", err)
+		}
+	}
+}
+
+// 检测证书、私钥文件是否变化
+func (cr *CertReloader) changed() bool {
+	certStat, err := statCertFile(cr.certFile)
+	if err != nil {
+		return false
+	}
+	keyStat, err := statCertFile(cr.keyFile)
+	if err != nil {
+		return false
+	}
+	cr.lock.RLock()
+	changed := certStat != cr.certStat || keyStat != cr.keyStat
+	cr.lock.RUnlock()
+	return changed
+}
+
+func (cr *CertReloader) logger() Logger {
+	if cr.Logger != nil {
+		return cr.Logger
+	}
+	return defaultLogger
+}
+
+func statCertFile(path string) (certFileStat, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return certFileStat{}, fmt.Errorf("cannot stat %q: %s", path, err)
+	}
+	return certFileStat{
+		modTime: fi.ModTime(),
+		size:    fi.Size(),
+	}, nil
+}
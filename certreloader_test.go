@@ -0,0 +1,103 @@
+package selfFastHttp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCertReloaderReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeCert := func(cn string) {
+		certData, keyData := newTestCert(t, cn)
+		if err := os.WriteFile(certFile, certData, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(keyFile, keyData, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	currentCN := func(cr *CertReloader) string {
+		cert, err := cr.GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return leaf.Subject.CommonName
+	}
+
+	writeCert("one")
+	cr, err := NewCertReloader(certFile, keyFile, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cr.Stop()
+	if cn := currentCN(cr); cn != "one" {
+		t.Fatalf("unexpected certificate %q, want %q", cn, "one")
+	}
+
+	writeCert("two")
+	if err := cr.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if cn := currentCN(cr); cn != "two" {
+		t.Fatalf("unexpected certificate %q, want %q", cn, "two")
+	}
+
+	// 加载失败时，保留旧证书
+	if err := os.WriteFile(keyFile, []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := cr.Reload(); err == nil {
+		t.Fatalf("expecting error when loading broken key")
+	}
+	if cn := currentCN(cr); cn != "two" {
+		t.Fatalf("unexpected certificate %q, want %q", cn, "two")
+	}
+}
+
+// 与AppendCert合用:SNI不匹配时，按追加的证书选择
+func TestCertReloaderSNI(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	certData, keyData := newTestCert(t, "reloaded", "reloaded.example")
+	if err := os.WriteFile(certFile, certData, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, keyData, 0600); err != nil {
+		t.Fatal(err)
+	}
+	cr, err := NewCertReloader(certFile, keyFile, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cr.Stop()
+
+	s := &Server{Handler: func(ctx *RequestCtx) {}, TLSConfig: &tls.Config{GetCertificate: cr.GetCertificate}}
+	certData, keyData = newTestCert(t, "a.example", "a.example")
+	if err := s.AppendCertEmbed(certData, keyData); err != nil {
+		t.Fatal(err)
+	}
+	addr := startTestTLSServer(t, s, nil, nil)
+
+	for _, tc := range []struct {
+		serverName string
+		cn         string
+	}{
+		{"reloaded.example", "reloaded"},
+		{"a.example", "a.example"},
+	} {
+		if cn := tlsPeerCN(t, addr, tc.serverName); cn != tc.cn {
+			t.Errorf("SNI %q: got certificate %q, want %q", tc.serverName, cn, tc.cn)
+		}
+	}
+}
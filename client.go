@@ -0,0 +1,877 @@
+package selfFastHttp
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 每个host的默认最大连接数
+const DefaultMaxConnsPerHost = 512
+
+// 空闲keep-alive连接的默认保留时间
+const DefaultMaxIdleConnDuration = 10 * time.Second
+
+// 幂等请求(GET,HEAD,PUT)的默认最大尝试次数
+const DefaultMaxIdemponentCallAttempts = 5
+
+// 默认最大重定向次数,见DoRedirects
+const defaultMaxRedirectsCount = 16
+
+var (
+	// 连接数已达MaxConns(MaxConnsPerHost)
+	ErrNoFreeConns = errors.New("no free connections available to host")
+
+	// 服务端在返回响应前关闭了连接
+	ErrConnectionClosed = errors.New("the server closed connection before returning the first response byte. " +
+		"Make sure the server returns 'Connection: close' response header before closing the connection")
+
+	// 建立连接、发送请求、读取响应超时(DoTimeout,DoDeadline,ReadTimeout,WriteTimeout)
+	ErrTimeout = errors.New("timeout")
+
+	// 重定向次数超过maxRedirectsCount
+	ErrTooManyRedirects = errors.New("too many redirects detected when doing the request")
+
+	// 重定向响应无Location头
+	ErrMissingLocation = errors.New("missing Location header for http redirect")
+)
+
+// 使用默认Client发送req,获取resp
+// 见Client.Do
+func Do(req *Request, resp *Response) error {
+	return defaultClient.Do(req, resp)
+}
+
+// 使用默认Client发送req,获取resp
+// 建立连接、发送请求、读取响应的总时间超过timeout时，返回ErrTimeout
+// 见Client.DoTimeout
+func DoTimeout(req *Request, resp *Response, timeout time.Duration) error {
+	return defaultClient.DoTimeout(req, resp, timeout)
+}
+
+// 使用默认Client发送req,获取resp
+// 在deadline前未完成时，返回ErrTimeout
+// 见Client.DoDeadline
+func DoDeadline(req *Request, resp *Response, deadline time.Time) error {
+	return defaultClient.DoDeadline(req, resp, deadline)
+}
+
+// 使用默认Client发送req,获取resp,跟随重定向
+// 见Client.DoRedirects
+func DoRedirects(req *Request, resp *Response, maxRedirectsCount int) error {
+	return defaultClient.DoRedirects(req, resp, maxRedirectsCount)
+}
+
+var defaultClient Client
+
+// http客户端
+// 按host(scheme+host)维护HostClient，复用keep-alive连接
+// 须用零值或指定参数创建，不可复制
+// 可在多个协程中并发使用
+type Client struct {
+	noCopy noCopy
+
+	// 'User-Agent'头,请求未设置时使用
+	// 默认defaultUserAgent
+	Name string
+
+	// 建立到host的连接
+	// 默认Dial(TCP4),见DialDualStack
+	Dial DialFunc
+
+	// 为true时,默认的Dial支持IPv6
+	DialDualStack bool
+
+	// https连接的tls配置
+	// 默认的配置校验服务端证书
+	TLSConfig *tls.Config
+
+	// 每个host的最大连接数
+	// 超出时Do返回ErrNoFreeConns
+	// 默认DefaultMaxConnsPerHost
+	MaxConnsPerHost int
+
+	// 空闲keep-alive连接超过该时间后关闭
+	// 默认DefaultMaxIdleConnDuration
+	MaxIdleConnDuration time.Duration
+
+	// 幂等请求出错时的最大尝试次数
+	// 默认DefaultMaxIdemponentCallAttempts
+	MaxIdemponentCallAttempts int
+
+	// 每个连接的读缓冲大小,同时限制响应头大小
+	// 默认defaultReadBufferSize
+	ReadBufferSize int
+
+	// 每个连接的写缓冲大小
+	// 默认defaultWriteBufferSize
+	WriteBufferSize int
+
+	// 读取完整响应(含body)的最长时间
+	// 默认无限制
+	ReadTimeout time.Duration
+
+	// 写入完整请求(含body)的最长时间
+	// 默认无限制
+	WriteTimeout time.Duration
+
+	// 响应body最大值,超出时Do返回ErrBodyTooLarge
+	// 默认无限制
+	MaxResponseBodySize int
+
+	// 不规范化请求、响应头名称
+	DisableHeaderNamesNormalizing bool
+
+	mLock sync.Mutex
+	m     map[string]*HostClient // http
+	ms    map[string]*HostClient // https
+}
+
+// 发送req,获取resp
+// req须包含完整url,如"http://foobar.com/aaa/bb?cc"，或设置Host头
+// resp须在不再使用后ReleaseResponse(若来自AcquireResponse)
+// 出错时返回ErrNoFreeConns、ErrConnectionClosed或连接错误等
+// 对同一host的连接数超过MaxConnsPerHost时，返回ErrNoFreeConns
+// 不跟随重定向,见DoRedirects
+func (c *Client) Do(req *Request, resp *Response) error {
+	return c.DoDeadline(req, resp, zeroTime)
+}
+
+// 同Do,建立连接、发送请求、读取响应的总时间超过timeout时，返回ErrTimeout
+func (c *Client) DoTimeout(req *Request, resp *Response, timeout time.Duration) error {
+	return c.DoDeadline(req, resp, time.Now().Add(timeout))
+}
+
+// 同Do,在deadline前未完成时，返回ErrTimeout
+// deadline为零值时，无总超时
+func (c *Client) DoDeadline(req *Request, resp *Response, deadline time.Time) error {
+	hc, err := c.hostClient(req)
+	if err != nil {
+		return err
+	}
+	return hc.DoDeadline(req, resp, deadline)
+}
+
+// 同Do,跟随301,302,303,307,308重定向,最多maxRedirectsCount次
+// 相对Location按req的url解析
+// 303时(HEAD除外)、301,302时POST请求改为GET请求,清空body及Content-Type
+// 重定向到其它host,或由https降级为http时，移除Authorization、Proxy-Authorization及Cookie头
+// req的url被更新为最后一次请求的url
+// maxRedirectsCount<=0时，使用默认值16
+func (c *Client) DoRedirects(req *Request, resp *Response, maxRedirectsCount int) error {
+	return c.doRedirects(req, resp, maxRedirectsCount, zeroTime)
+}
+
+// 同DoRedirects,所有请求在deadline前未完成时，返回ErrTimeout
+func (c *Client) DoRedirectsDeadline(req *Request, resp *Response, maxRedirectsCount int, deadline time.Time) error {
+	return c.doRedirects(req, resp, maxRedirectsCount, deadline)
+}
+
+func (c *Client) doRedirects(req *Request, resp *Response, maxRedirectsCount int, deadline time.Time) error {
+	if maxRedirectsCount <= 0 {
+		maxRedirectsCount = defaultMaxRedirectsCount
+	}
+	redirectsCount := 0
+	var prevScheme, prevHost []byte
+	for {
+		if err := c.DoDeadline(req, resp, deadline); err != nil {
+			return err
+		}
+		statusCode := resp.StatusCode()
+		if !statusCodeIsRedirect(statusCode) {
+			return nil
+		}
+		redirectsCount++
+		if redirectsCount > maxRedirectsCount {
+			return ErrTooManyRedirects
+		}
+		location := resp.Header.peek(strLocation)
+		if len(location) == 0 {
+			return ErrMissingLocation
+		}
+		uri := req.URI()
+		prevScheme = append(prevScheme[:0], uri.Scheme()...)
+		prevHost = append(prevHost[:0], uri.Host()...)
+		uri.UpdateBytes(location)
+		if !bytes.EqualFold(prevHost, uri.Host()) ||
+			(bytes.Equal(prevScheme, strHTTPS) && !bytes.Equal(uri.Scheme(), strHTTPS)) {
+			req.Header.Del("Authorization")
+			req.Header.Del("Proxy-Authorization")
+			req.Header.DelAllCookies()
+		}
+		if redirectChangesMethodToGet(statusCode, &req.Header) {
+			req.Header.SetMethodBytes(strGet)
+			req.ResetBody()
+			req.Header.SetContentType("")
+		}
+	}
+}
+
+// 303:除HEAD外均改为GET;301,302:POST改为GET(同net/http及浏览器)
+// 307,308:保持请求方法及body
+func redirectChangesMethodToGet(statusCode int, h *RequestHeader) bool {
+	switch statusCode {
+	case StatusSeeOther:
+		return !h.IsHead()
+	case StatusMovedPermanently, StatusFound:
+		return h.IsPost()
+	}
+	return false
+}
+
+// 301,302,303,307,308
+func statusCodeIsRedirect(statusCode int) bool {
+	return statusCode == StatusMovedPermanently || statusCode == StatusFound ||
+		statusCode == StatusSeeOther || statusCode == StatusTemporaryRedirect ||
+		statusCode == StatusPermanentRedirect
+}
+
+// 按req的scheme、host取HostClient,无则新建
+func (c *Client) hostClient(req *Request) (*HostClient, error) {
+	uri := req.URI()
+	host := uri.Host()
+	if len(host) == 0 {
+		return nil, errRequestHostRequired
+	}
+
+	isTLS := false
+	scheme := uri.Scheme()
+	if bytes.Equal(scheme, strHTTPS) {
+		isTLS = true
+	} else if !bytes.Equal(scheme, strHTTP) {
+		return nil, fmt.Errorf("unsupported protocol %q. http and https are supported", scheme)
+	}
+
+	startCleaner := false
+
+	c.mLock.Lock()
+	m := c.m
+	if isTLS {
+		m = c.ms
+	}
+	if m == nil {
+		m = make(map[string]*HostClient)
+		if isTLS {
+			c.ms = m
+		} else {
+			c.m = m
+		}
+	}
+	hc := m[string(host)]
+	if hc == nil {
+		hc = &HostClient{
+			Addr:                          addMissingPort(string(host), isTLS),
+			Name:                          c.Name,
+			Dial:                          c.Dial,
+			DialDualStack:                 c.DialDualStack,
+			IsTLS:                         isTLS,
+			TLSConfig:                     c.TLSConfig,
+			MaxConns:                      c.MaxConnsPerHost,
+			MaxIdleConnDuration:           c.MaxIdleConnDuration,
+			MaxIdemponentCallAttempts:     c.MaxIdemponentCallAttempts,
+			ReadBufferSize:                c.ReadBufferSize,
+			WriteBufferSize:               c.WriteBufferSize,
+			ReadTimeout:                   c.ReadTimeout,
+			WriteTimeout:                  c.WriteTimeout,
+			MaxResponseBodySize:           c.MaxResponseBodySize,
+			DisableHeaderNamesNormalizing: c.DisableHeaderNamesNormalizing,
+		}
+		if len(m) == 0 {
+			startCleaner = true
+		}
+		m[string(host)] = hc
+	}
+	c.mLock.Unlock()
+
+	if startCleaner {
+		go c.mCleaner(m)
+	}
+	return hc, nil
+}
+
+// 定期移除无连接的HostClient
+// m为空时退出
+func (c *Client) mCleaner(m map[string]*HostClient) {
+	mustStop := false
+	for {
+		time.Sleep(10 * time.Second)
+		c.mLock.Lock()
+		for k, v := range m {
+			v.connsLock.Lock()
+			shouldRemove := v.connsCount == 0
+			v.connsLock.Unlock()
+			if shouldRemove {
+				delete(m, k)
+			}
+		}
+		if len(m) == 0 {
+			mustStop = true
+		}
+		c.mLock.Unlock()
+
+		if mustStop {
+			break
+		}
+	}
+}
+
+// 单个host的http客户端
+// 维护到Addr的keep-alive连接池(LIFO),超过MaxIdleConnDuration的空闲连接被关闭
+// 须用零值或指定参数创建，不可复制
+// 可在多个协程中并发使用
+type HostClient struct {
+	noCopy noCopy
+
+	// host地址,须含端口,如"foobar.com:80"
+	Addr string
+
+	// 'User-Agent'头,请求未设置时使用
+	// 默认defaultUserAgent
+	Name string
+
+	// 建立到Addr的连接
+	// 默认Dial(TCP4),见DialDualStack
+	Dial DialFunc
+
+	// 为true时,默认的Dial支持IPv6
+	DialDualStack bool
+
+	// 是否使用tls连接
+	IsTLS bool
+
+	// tls连接的配置
+	// 默认的配置校验服务端证书,ServerName未设置时使用Addr中的host
+	TLSConfig *tls.Config
+
+	// 最大连接数
+	// 超出时Do返回ErrNoFreeConns
+	// 默认DefaultMaxConnsPerHost
+	MaxConns int
+
+	// 空闲keep-alive连接超过该时间后关闭
+	// 默认DefaultMaxIdleConnDuration
+	MaxIdleConnDuration time.Duration
+
+	// 幂等请求出错时的最大尝试次数
+	// 默认DefaultMaxIdemponentCallAttempts
+	MaxIdemponentCallAttempts int
+
+	// 每个连接的读缓冲大小,同时限制响应头大小
+	// 默认defaultReadBufferSize
+	ReadBufferSize int
+
+	// 每个连接的写缓冲大小
+	// 默认defaultWriteBufferSize
+	WriteBufferSize int
+
+	// 读取完整响应(含body)的最长时间
+	// 默认无限制
+	ReadTimeout time.Duration
+
+	// 写入完整请求(含body)的最长时间
+	// 默认无限制
+	WriteTimeout time.Duration
+
+	// 响应body最大值,超出时Do返回ErrBodyTooLarge
+	// 默认无限制
+	MaxResponseBodySize int
+
+	// 不规范化请求、响应头名称
+	DisableHeaderNamesNormalizing bool
+
+	pendingRequests int32 // 正在处理的请求数
+
+	connsLock       sync.Mutex
+	connsCount      int           // 已建立的连接数,含使用中的
+	conns           []*clientConn // 空闲连接,按最后使用时间升序
+	connsCleanerRun bool
+
+	tlsConfigLock   sync.Mutex
+	cachedTLSConfig *tls.Config
+
+	readerPool sync.Pool
+	writerPool sync.Pool
+}
+
+type clientConn struct {
+	c net.Conn
+
+	createdTime time.Time
+	lastUseTime time.Time
+}
+
+var clientConnPool sync.Pool
+
+func acquireClientConn(conn net.Conn) *clientConn {
+	v := clientConnPool.Get()
+	if v == nil {
+		v = &clientConn{}
+	}
+	cc := v.(*clientConn)
+	cc.c = conn
+	cc.createdTime = time.Now()
+	return cc
+}
+
+func releaseClientConn(cc *clientConn) {
+	cc.c = nil
+	clientConnPool.Put(cc)
+}
+
+// 发送req,获取resp
+// 幂等请求(GET,HEAD,PUT)出错时，在新的或其它空闲连接上重试，最多MaxIdemponentCallAttempts次
+// 连接数超过MaxConns时，返回ErrNoFreeConns
+func (c *HostClient) Do(req *Request, resp *Response) error {
+	return c.DoDeadline(req, resp, zeroTime)
+}
+
+// 同Do,建立连接、发送请求、读取响应的总时间超过timeout时，返回ErrTimeout
+func (c *HostClient) DoTimeout(req *Request, resp *Response, timeout time.Duration) error {
+	return c.DoDeadline(req, resp, time.Now().Add(timeout))
+}
+
+// 同Do,在deadline前未完成时，返回ErrTimeout
+// deadline为零值时，无总超时
+func (c *HostClient) DoDeadline(req *Request, resp *Response, deadline time.Time) error {
+	atomic.AddInt32(&c.pendingRequests, 1)
+	defer atomic.AddInt32(&c.pendingRequests, -1)
+
+	var err error
+	var retry bool
+	maxAttempts := c.MaxIdemponentCallAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxIdemponentCallAttempts
+	}
+	isIdempotent := req.Header.IsGet() || req.Header.IsHead() || req.Header.IsPut()
+	attempts := 0
+	for {
+		retry, err = c.do(req, resp, deadline)
+		if err == nil || !retry || !isIdempotent {
+			break
+		}
+		attempts++
+		if attempts >= maxAttempts {
+			break
+		}
+	}
+	if err == io.EOF {
+		err = ErrConnectionClosed
+	} else if isClientTimeoutError(err) {
+		err = ErrTimeout
+	}
+	return err
+}
+
+// 读写连接超时
+func isClientTimeoutError(err error) bool {
+	if _, ok := err.(*ErrReadTimeout); ok {
+		return true
+	}
+	return isTimeoutError(err)
+}
+
+// 返回now+timeout与deadline中较早的,均未设置时返回零值
+func clientDeadline(timeout time.Duration, deadline time.Time) time.Time {
+	if timeout <= 0 {
+		return deadline
+	}
+	d := time.Now().Add(timeout)
+	if deadline.IsZero() || d.Before(deadline) {
+		return d
+	}
+	return deadline
+}
+
+// 返回值retry:出错时，请求是否可在其它连接上重试
+// deadline:建立连接、发送请求、读取响应的截止时间,零值时无限制
+func (c *HostClient) do(req *Request, resp *Response, deadline time.Time) (bool, error) {
+	if req == nil {
+		panic("BUG: req cannot be nil")
+	}
+	if resp == nil {
+		panic("BUG: resp cannot be nil")
+	}
+
+	// HEAD请求的响应无body
+	skipBody := resp.SkipBody
+	resp.SkipBody = skipBody || req.Header.IsHead()
+	defer func() {
+		resp.SkipBody = skipBody
+	}()
+
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return false, ErrTimeout
+	}
+
+	cc, err := c.acquireConn(deadline)
+	if err != nil {
+		return false, err
+	}
+	conn := cc.c
+
+	// 复用的连接可能留有上次请求的deadline,每次都重新设置
+	// tls握手在首次写入时进行,须同时设置读deadline
+	if err = conn.SetWriteDeadline(clientDeadline(c.WriteTimeout, deadline)); err == nil {
+		err = conn.SetReadDeadline(deadline)
+	}
+	if err != nil {
+		c.closeConn(cc)
+		return true, err
+	}
+
+	// 请求未设置User-Agent时，临时使用Name
+	userAgentOld := req.Header.userAgent
+	if len(userAgentOld) == 0 {
+		req.Header.userAgent = c.getClientName()
+	}
+	if c.DisableHeaderNamesNormalizing {
+		req.Header.DisableNormalizing()
+		resp.Header.DisableNormalizing()
+	}
+	bw := c.acquireWriter(conn)
+	err = req.Write(bw)
+	if len(userAgentOld) == 0 {
+		req.Header.userAgent = userAgentOld
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	c.releaseWriter(bw)
+	if err != nil {
+		c.closeConn(cc)
+		return !isClientTimeoutError(err), err
+	}
+
+	if c.ReadTimeout > 0 {
+		if err = conn.SetReadDeadline(clientDeadline(c.ReadTimeout, deadline)); err != nil {
+			c.closeConn(cc)
+			return true, err
+		}
+	}
+
+	br := c.acquireReader(conn)
+	if err = resp.ReadLimitBody(br, c.MaxResponseBodySize); err != nil {
+		c.releaseReader(br)
+		c.closeConn(cc)
+		return err != ErrBodyTooLarge && !isClientTimeoutError(err), err
+	}
+	c.releaseReader(br)
+
+	if req.ConnectionClose() || resp.ConnectionClose() {
+		c.closeConn(cc)
+	} else {
+		c.releaseConn(cc)
+	}
+	return false, nil
+}
+
+func (c *HostClient) getClientName() []byte {
+	if len(c.Name) > 0 {
+		return s2b(c.Name)
+	}
+	return defaultUserAgent
+}
+
+// 返回正在处理的请求数
+func (c *HostClient) PendingRequests() int {
+	return int(atomic.LoadInt32(&c.pendingRequests))
+}
+
+// 返回已建立连接数(含使用中的)
+func (c *HostClient) ConnsCount() int {
+	c.connsLock.Lock()
+	n := c.connsCount
+	c.connsLock.Unlock()
+	return n
+}
+
+// 取最近使用的空闲连接;无空闲连接时，新建之
+// 连接数已达MaxConns时，返回ErrNoFreeConns
+func (c *HostClient) acquireConn(deadline time.Time) (*clientConn, error) {
+	var cc *clientConn
+	createConn := false
+	startCleaner := false
+
+	c.connsLock.Lock()
+	n := len(c.conns)
+	if n == 0 {
+		maxConns := c.MaxConns
+		if maxConns <= 0 {
+			maxConns = DefaultMaxConnsPerHost
+		}
+		if c.connsCount < maxConns {
+			c.connsCount++
+			createConn = true
+			if !c.connsCleanerRun {
+				startCleaner = true
+				c.connsCleanerRun = true
+			}
+		}
+	} else {
+		n--
+		cc = c.conns[n]
+		c.conns[n] = nil
+		c.conns = c.conns[:n]
+	}
+	c.connsLock.Unlock()
+
+	if cc != nil {
+		return cc, nil
+	}
+	if !createConn {
+		return nil, ErrNoFreeConns
+	}
+
+	if startCleaner {
+		go c.connsCleaner()
+	}
+
+	conn, err := c.dialHost(deadline)
+	if err != nil {
+		c.decConnsCount()
+		return nil, err
+	}
+	return acquireClientConn(conn), nil
+}
+
+// 关闭空闲超过MaxIdleConnDuration的连接
+// 无连接时退出，下次新建连接时再启动
+func (c *HostClient) connsCleaner() {
+	var scratch []*clientConn
+	maxIdleConnDuration := c.MaxIdleConnDuration
+	if maxIdleConnDuration <= 0 {
+		maxIdleConnDuration = DefaultMaxIdleConnDuration
+	}
+	for {
+		currentTime := time.Now()
+
+		// 空闲连接按最后使用时间升序,从头开始找过期的
+		c.connsLock.Lock()
+		conns := c.conns
+		n := len(conns)
+		i := 0
+		for i < n && currentTime.Sub(conns[i].lastUseTime) > maxIdleConnDuration {
+			i++
+		}
+		sleepFor := maxIdleConnDuration
+		if i < n {
+			// 下一个连接过期时再检查
+			sleepFor = maxIdleConnDuration - currentTime.Sub(conns[i].lastUseTime) + 1
+		}
+		scratch = append(scratch[:0], conns[:i]...)
+		if i > 0 {
+			m := copy(conns, conns[i:])
+			for i = m; i < n; i++ {
+				conns[i] = nil
+			}
+			c.conns = conns[:m]
+		}
+		c.connsLock.Unlock()
+
+		for i, cc := range scratch {
+			c.closeConn(cc)
+			scratch[i] = nil
+		}
+
+		c.connsLock.Lock()
+		mustStop := c.connsCount == 0
+		if mustStop {
+			c.connsCleanerRun = false
+		}
+		c.connsLock.Unlock()
+		if mustStop {
+			break
+		}
+
+		time.Sleep(sleepFor)
+	}
+}
+
+func (c *HostClient) closeConn(cc *clientConn) {
+	c.decConnsCount()
+	cc.c.Close()
+	releaseClientConn(cc)
+}
+
+func (c *HostClient) decConnsCount() {
+	c.connsLock.Lock()
+	c.connsCount--
+	c.connsLock.Unlock()
+}
+
+// 还回空闲连接
+func (c *HostClient) releaseConn(cc *clientConn) {
+	cc.lastUseTime = time.Now()
+	c.connsLock.Lock()
+	c.conns = append(c.conns, cc)
+	c.connsLock.Unlock()
+}
+
+// --- Reader pool 读缓冲器
+func (c *HostClient) acquireReader(conn net.Conn) *bufio.Reader {
+	v := c.readerPool.Get()
+	if v == nil {
+		n := c.ReadBufferSize
+		if n <= 0 {
+			n = defaultReadBufferSize
+		}
+		return bufio.NewReaderSize(conn, n)
+	}
+	br := v.(*bufio.Reader)
+	br.Reset(conn)
+	return br
+}
+func (c *HostClient) releaseReader(br *bufio.Reader) {
+	br.Reset(nil)
+	c.readerPool.Put(br)
+}
+
+// --- Writer pool 写缓冲器
+func (c *HostClient) acquireWriter(conn net.Conn) *bufio.Writer {
+	v := c.writerPool.Get()
+	if v == nil {
+		n := c.WriteBufferSize
+		if n <= 0 {
+			n = defaultWriteBufferSize
+		}
+		return bufio.NewWriterSize(conn, n)
+	}
+	bw := v.(*bufio.Writer)
+	bw.Reset(conn)
+	return bw
+}
+func (c *HostClient) releaseWriter(bw *bufio.Writer) {
+	bw.Reset(nil)
+	c.writerPool.Put(bw)
+}
+
+// 建立到Addr的连接,IsTLS时封装为tls客户端连接
+// deadline前未建立时，返回ErrTimeout
+func (c *HostClient) dialHost(deadline time.Time) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	if deadline.IsZero() {
+		conn, err = c.dial()
+	} else {
+		conn, err = c.dialDeadline(deadline)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if conn == nil {
+		panic("BUG: DialFunc returned (nil, nil)")
+	}
+	if c.IsTLS {
+		conn = tls.Client(conn, c.tlsConfig())
+	}
+	return conn, nil
+}
+
+func (c *HostClient) dial() (net.Conn, error) {
+	return dialAddr(c.Addr, c.Dial, c.DialDualStack)
+}
+
+// 用dial建立到addr的连接,dial为nil时使用Dial或DialDualStack
+func dialAddr(addr string, dial DialFunc, dialDualStack bool) (net.Conn, error) {
+	if dial != nil {
+		return dial(addr)
+	}
+	if dialDualStack {
+		return DialDualStack(addr)
+	}
+	return Dial(addr)
+}
+
+func (c *HostClient) dialDeadline(deadline time.Time) (net.Conn, error) {
+	timeout := time.Until(deadline)
+	if timeout <= 0 {
+		return nil, ErrTimeout
+	}
+	if c.Dial == nil {
+		var conn net.Conn
+		var err error
+		if c.DialDualStack {
+			conn, err = DialDualStackTimeout(c.Addr, timeout)
+		} else {
+			conn, err = DialTimeout(c.Addr, timeout)
+		}
+		if isTimeoutError(err) {
+			err = ErrTimeout
+		}
+		return conn, err
+	}
+
+	// 自定义的Dial无超时参数,超时后放弃等待,稍后建立的连接被关闭
+	ch := make(chan dialResult, 1)
+	go func() {
+		conn, err := c.Dial(c.Addr)
+		ch <- dialResult{conn, err}
+	}()
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case r := <-ch:
+		return r.conn, r.err
+	case <-t.C:
+		go func() {
+			if r := <-ch; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, ErrTimeout
+	}
+}
+
+type dialResult struct {
+	conn net.Conn
+	err  error
+}
+
+// 返回tls客户端配置
+func (c *HostClient) tlsConfig() *tls.Config {
+	c.tlsConfigLock.Lock()
+	defer c.tlsConfigLock.Unlock()
+	if c.cachedTLSConfig == nil {
+		c.cachedTLSConfig = newClientTLSConfig(c.TLSConfig, c.Addr)
+	}
+	return c.cachedTLSConfig
+}
+
+// 返回到addr的tls客户端配置:c的副本,ServerName未设置时使用addr中的host
+func newClientTLSConfig(c *tls.Config, addr string) *tls.Config {
+	var cfg *tls.Config
+	if c == nil {
+		cfg = &tls.Config{
+			ClientSessionCache: tls.NewLRUClientSessionCache(0),
+		}
+	} else {
+		cfg = c.Clone()
+	}
+	if len(cfg.ServerName) == 0 {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		cfg.ServerName = host
+	}
+	return cfg
+}
+
+// addr无端口时，补上默认端口(http:80, https:443)
+func addMissingPort(addr string, isTLS bool) string {
+	if strings.LastIndexByte(addr, ':') > strings.LastIndexByte(addr, ']') {
+		return addr
+	}
+	if isTLS {
+		return addr + ":443"
+	}
+	return addr + ":80"
+}
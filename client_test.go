@@ -0,0 +1,209 @@
+package selfFastHttp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/forTWOS/selfFastHttp/selffasthttputil"
+)
+
+// 返回连接到ln的DialFunc,dials记录建立的连接数
+func inmemoryDial(ln *selffasthttputil.InmemoryListener, dials *int32) DialFunc {
+	return func(addr string) (net.Conn, error) {
+		atomic.AddInt32(dials, 1)
+		return ln.Dial()
+	}
+}
+
+// 用do发送GET请求,返回响应body
+func testClientGet(t *testing.T, do func(req *Request, resp *Response) error, url string) string {
+	req := AcquireRequest()
+	resp := AcquireResponse()
+	defer ReleaseRequest(req)
+	defer ReleaseResponse(resp)
+	req.SetRequestURI(url)
+	if err := do(req, resp); err != nil {
+		t.Fatalf("%s: unexpected error %v", url, err)
+	}
+	if resp.StatusCode() != StatusOK {
+		t.Fatalf("%s: unexpected status code %d", url, resp.StatusCode())
+	}
+	return string(resp.Body())
+}
+
+// keep-alive连接被复用
+func TestHostClientConnPool(t *testing.T) {
+	s := &Server{Handler: func(ctx *RequestCtx) { ctx.Write(ctx.Path()) }}
+	ln, _ := startInmemoryServer(t, s)
+	var dials int32
+	c := &HostClient{Addr: "example.com:80", Dial: inmemoryDial(ln, &dials)}
+
+	for _, path := range []string{"/a", "/b", "/c"} {
+		if body := testClientGet(t, c.Do, "http://example.com"+path); body != path {
+			t.Fatalf("unexpected body %q, want %q", body, path)
+		}
+	}
+	if n := atomic.LoadInt32(&dials); n != 1 {
+		t.Fatalf("unexpected dials %d, want 1", n)
+	}
+	if n := c.ConnsCount(); n != 1 {
+		t.Fatalf("unexpected ConnsCount %d, want 1", n)
+	}
+}
+
+func TestHostClientMaxConns(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s := &Server{Handler: func(ctx *RequestCtx) {
+		close(started)
+		<-release
+	}}
+	ln, _ := startInmemoryServer(t, s)
+	var dials int32
+	c := &HostClient{Addr: "example.com:80", Dial: inmemoryDial(ln, &dials), MaxConns: 1}
+
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	req.SetRequestURI("http://example.com/")
+	doneCh := make(chan error, 1)
+	go func() { doneCh <- c.Do(req, &Response{}) }()
+	<-started
+
+	var req2 Request
+	req2.SetRequestURI("http://example.com/")
+	if err := c.Do(&req2, &Response{}); err != ErrNoFreeConns {
+		t.Fatalf("unexpected error %v, want %v", err, ErrNoFreeConns)
+	}
+	close(release)
+	if err := <-doneCh; err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+// 空闲超过MaxIdleConnDuration的连接被关闭
+func TestHostClientMaxIdleConnDuration(t *testing.T) {
+	s := &Server{Handler: func(ctx *RequestCtx) {}}
+	ln, _ := startInmemoryServer(t, s)
+	var dials int32
+	c := &HostClient{
+		Addr:                "example.com:80",
+		Dial:                inmemoryDial(ln, &dials),
+		MaxIdleConnDuration: 50 * time.Millisecond,
+	}
+
+	testClientGet(t, c.Do, "http://example.com/")
+	for start := time.Now(); c.ConnsCount() != 0; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatalf("idle conn is not closed")
+		}
+	}
+	testClientGet(t, c.Do, "http://example.com/")
+	if n := atomic.LoadInt32(&dials); n != 2 {
+		t.Fatalf("unexpected dials %d, want 2", n)
+	}
+}
+
+// 按host分别维护连接
+func TestClientHosts(t *testing.T) {
+	s := &Server{Handler: func(ctx *RequestCtx) { ctx.Write(ctx.Host()) }}
+	ln, _ := startInmemoryServer(t, s)
+	var dials int32
+	c := &Client{Dial: inmemoryDial(ln, &dials)}
+
+	for i := 0; i < 2; i++ {
+		for _, host := range []string{"a.example", "b.example"} {
+			if body := testClientGet(t, c.Do, "http://"+host+"/"); body != host {
+				t.Fatalf("unexpected body %q, want %q", body, host)
+			}
+		}
+	}
+	if n := atomic.LoadInt32(&dials); n != 2 {
+		t.Fatalf("unexpected dials %d, want 2", n)
+	}
+}
+
+func TestHostClientTLS(t *testing.T) {
+	certData, keyData := newTestCert(t, "server", "server.example")
+	s := &Server{Handler: func(ctx *RequestCtx) {
+		if ctx.IsTLS() {
+			ctx.WriteString("tls")
+		}
+	}}
+	addr := startTestTLSServer(t, s, certData, keyData)
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(certData)
+
+	// 校验服务端证书,ServerName取自Addr中的host
+	c := &HostClient{
+		Addr:      "server.example:443",
+		Dial:      func(string) (net.Conn, error) { return net.Dial("tcp4", addr) },
+		IsTLS:     true,
+		TLSConfig: &tls.Config{RootCAs: pool},
+	}
+	if body := testClientGet(t, c.Do, "https://server.example/"); body != "tls" {
+		t.Fatalf("unexpected body %q", body)
+	}
+
+	// 证书不可信
+	c = &HostClient{
+		Addr:  "server.example:443",
+		Dial:  func(string) (net.Conn, error) { return net.Dial("tcp4", addr) },
+		IsTLS: true,
+	}
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	req.SetRequestURI("https://server.example/")
+	if err := c.Do(req, &Response{}); err == nil {
+		t.Fatal("expecting certificate verification error")
+	}
+}
+
+func TestClientDoRedirects(t *testing.T) {
+	s := &Server{Handler: func(ctx *RequestCtx) {
+		switch string(ctx.Path()) {
+		case "/relative":
+			ctx.Response.Header.Set("Location", "/done?next=http://evil.example/")
+			ctx.SetStatusCode(StatusFound)
+		case "/absolute":
+			ctx.Response.Header.Set("Location", "http://b.example/done")
+			ctx.SetStatusCode(StatusMovedPermanently)
+		case "/scheme-relative":
+			ctx.Response.Header.Set("Location", "//b.example/done")
+			ctx.SetStatusCode(StatusTemporaryRedirect)
+		case "/loop":
+			ctx.Response.Header.Set("Location", "loop")
+			ctx.SetStatusCode(StatusFound)
+		default:
+			ctx.Write(ctx.Host())
+			ctx.Write(ctx.RequestURI())
+		}
+	}}
+	ln, _ := startInmemoryServer(t, s)
+	var dials int32
+	c := &Client{Dial: inmemoryDial(ln, &dials)}
+	do := func(req *Request, resp *Response) error { return c.DoRedirects(req, resp, 0) }
+
+	for _, tc := range []struct {
+		url, want string
+	}{
+		// query中含//时仍为相对地址
+		{"http://a.example/relative", "a.example/done?next=http://evil.example/"},
+		{"http://a.example/absolute", "b.example/done"},
+		{"http://a.example/scheme-relative", "b.example/done"},
+	} {
+		if body := testClientGet(t, do, tc.url); body != tc.want {
+			t.Fatalf("%s: unexpected body %q, want %q", tc.url, body, tc.want)
+		}
+	}
+
+	req := AcquireRequest()
+	defer ReleaseRequest(req)
+	req.SetRequestURI("http://a.example/loop")
+	if err := c.DoRedirects(req, &Response{}, 3); err != ErrTooManyRedirects {
+		t.Fatalf("unexpected error %v, want %v", err, ErrTooManyRedirects)
+	}
+}
@@ -56,9 +56,11 @@ var (
 	strAcceptRanges     = []byte("Accept-Ranges")
 	strRange            = []byte("Range")
 	strContentRange     = []byte("Content-Range")
+	strRetryAfter       = []byte("Retry-After")
 
 	// Cookie
 	strCookieExpires  = []byte("expires")
+	strCookieMaxAge   = []byte("Max-Age")
 	strCookieDomain   = []byte("domain")
 	strCookiePath     = []byte("path")
 	strCookieHTTPOnly = []byte("HttpOnly") // 使cookie在浏览器中不可见-js、applet不可获得,防xss攻击
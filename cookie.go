@@ -42,6 +42,7 @@ type Cookie struct {
 	key    []byte
 	value  []byte
 	expire time.Time
+	maxAge int // 秒,0:未设置
 	domain []byte
 	path   []byte
 
@@ -58,6 +59,7 @@ func (c *Cookie) CopyTo(dst *Cookie) {
 	dst.key = append(dst.key[:0], c.key...)
 	dst.value = append(dst.value[:0], c.value...)
 	dst.expire = c.expire
+	dst.maxAge = c.maxAge
 	dst.domain = append(dst.domain[:0], c.domain...)
 	dst.path = append(dst.path[:0], c.path...)
 
@@ -124,6 +126,16 @@ func (c *Cookie) SetExpire(expire time.Time) {
 	c.expire = expire
 }
 
+// - max-age
+// 有效秒数,0:未设置,优先于expire
+func (c *Cookie) MaxAge() int {
+	return c.maxAge
+}
+
+func (c *Cookie) SetMaxAge(seconds int) {
+	c.maxAge = seconds
+}
+
 // - value
 func (c *Cookie) Value() []byte {
 	return c.value
@@ -150,6 +162,7 @@ func (c *Cookie) Reset() {
 	c.key = c.key[:0]
 	c.value = c.value[:0]
 	c.expire = zeroTime
+	c.maxAge = 0
 	c.domain = c.domain[:0]
 	c.path = c.path[:0]
 	c.httpOnly = false
@@ -169,6 +182,11 @@ func (c *Cookie) AppendBytes(dst []byte) []byte {
 	}
 	dst = append(dst, c.value...)
 
+	if c.maxAge > 0 {
+		c.bufKV.value = AppendUint(c.bufKV.value[:0], c.maxAge)
+		dst = appendCookiePart(dst, strCookieMaxAge, c.bufKV.value)
+	}
+	// 同时输出expires:不支持Max-Age的客户端使用之
 	if !c.expire.IsZero() {
 		c.bufKV.value = AppendHTTPDate(c.bufKV.value[:0], c.expire)
 		dst = append(dst, ';', ' ')
@@ -216,6 +234,8 @@ func (c *Cookie) Parse(src string) error {
 }
 
 // 解析 'Set-Cookie: xxx'头
+// 属性名不区分大小写;Max-Age<=0时，expire设为CookieExpireDelete
+// 值无效的Max-Age被忽略
 func (c *Cookie) ParseBytes(src []byte) error {
 	c.Reset()
 
@@ -230,34 +250,100 @@ func (c *Cookie) ParseBytes(src []byte) error {
 	c.key = append(c.key[:0], kv.key...)
 	c.value = append(c.value[:0], kv.value...)
 
+	var maxAge int
+	var hasMaxAge bool
 	for s.next(kv) {
 		if len(kv.key) == 0 && len(kv.value) == 0 {
 			continue
 		}
-		switch string(kv.key) {
-		case "expires":
-			v := b2s(kv.value)
-			exptime, err := time.ParseInLocation(time.RFC1123, v, time.UTC)
+		switch {
+		case bytes.EqualFold(kv.key, strCookieExpires):
+			exptime, err := parseCookieExpire(b2s(kv.value))
 			if err != nil {
 				return err
 			}
 			c.expire = exptime
-		case "domain":
+		case bytes.EqualFold(kv.key, strCookieMaxAge):
+			if n, ok := parseCookieMaxAge(kv.value); ok {
+				maxAge = n
+				hasMaxAge = true
+			}
+		case bytes.EqualFold(kv.key, strCookieDomain):
 			c.domain = append(c.domain[:0], kv.value...)
-		case "path":
+		case bytes.EqualFold(kv.key, strCookiePath):
 			c.path = append(c.path[:0], kv.value...)
-		case "":
-			switch string(kv.value) {
-			case "HttpOnly":
+		case len(kv.key) == 0:
+			if bytes.EqualFold(kv.value, strCookieHTTPOnly) {
 				c.httpOnly = true
-			case "secure":
+			} else if bytes.EqualFold(kv.value, strCookieSecure) {
 				c.secure = true
 			}
 		}
 	}
+
+	// Max-Age优先于expires,与属性顺序无关
+	if hasMaxAge {
+		if maxAge > 0 {
+			c.maxAge = maxAge
+		} else {
+			c.expire = CookieExpireDelete
+		}
+	}
 	return nil
 }
 
+// 解析的Max-Age的上限(秒,约68年),避免转为time.Duration时溢出
+const cookieMaxAgeLimit = 1<<31 - 1
+
+// RFC 6265 5.2.2:值须为数字,可带'-';否则ok为false,忽略该属性
+// 超过cookieMaxAgeLimit的值截断为cookieMaxAgeLimit
+func parseCookieMaxAge(v []byte) (int, bool) {
+	neg := len(v) > 0 && v[0] == '-'
+	if neg {
+		v = v[1:]
+	}
+	if len(v) == 0 {
+		return 0, false
+	}
+	n := 0
+	for _, ch := range v {
+		if ch < '0' || ch > '9' {
+			return 0, false
+		}
+		d := int(ch - '0')
+		if n > (cookieMaxAgeLimit-d)/10 {
+			n = cookieMaxAgeLimit
+		} else {
+			n = n*10 + d
+		}
+	}
+	if neg {
+		return -n, true
+	}
+	return n, true
+}
+
+// expires的格式:
+// Sun, 24 Jun 2018 09:53:08 GMT
+// Sun, 24-Jun-2018 09:53:08 GMT
+// Thu, 23-May-19 03:06:16 GMT
+var cookieExpireLayouts = []string{
+	time.RFC1123,
+	"Mon, 02-Jan-2006 15:04:05 MST",
+	"Mon, 02-Jan-06 15:04:05 MST",
+}
+
+func parseCookieExpire(v string) (time.Time, error) {
+	var err error
+	for _, layout := range cookieExpireLayouts {
+		var t time.Time
+		if t, err = time.ParseInLocation(layout, v, time.UTC); err == nil {
+			return t, nil
+		}
+	}
+	return zeroTime, err
+}
+
 func appendCookiePart(dst, key, value []byte) []byte {
 	dst = append(dst, ';', ' ')
 	dst = append(dst, key...)
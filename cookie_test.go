@@ -0,0 +1,82 @@
+package selfFastHttp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCookieAppendBytes(t *testing.T) {
+	expire := time.Date(2030, time.January, 2, 3, 4, 5, 0, time.UTC)
+	for _, tc := range []struct {
+		maxAge int
+		expire time.Time
+		want   string
+	}{
+		{0, zeroTime, "k=v"},
+		{60, zeroTime, "k=v; Max-Age=60"},
+		{0, expire, "k=v; expires=Wed, 02 Jan 2030 03:04:05 GMT"},
+		{60, expire, "k=v; Max-Age=60; expires=Wed, 02 Jan 2030 03:04:05 GMT"},
+		{0, CookieExpireDelete, "k=v; expires=Tue, 10 Nov 2009 23:00:00 GMT"},
+	} {
+		var c Cookie
+		c.SetKey("k")
+		c.SetValue("v")
+		c.SetMaxAge(tc.maxAge)
+		c.SetExpire(tc.expire)
+		if s := c.String(); s != tc.want {
+			t.Errorf("maxAge=%d expire=%v: got %q, want %q", tc.maxAge, tc.expire, s, tc.want)
+		}
+	}
+}
+
+func TestCookieParseMaxAge(t *testing.T) {
+	const expires = "expires=Wed, 02 Jan 2030 03:04:05 GMT"
+	expire := time.Date(2030, time.January, 2, 3, 4, 5, 0, time.UTC)
+	for _, tc := range []struct {
+		src    string
+		maxAge int
+		expire time.Time
+	}{
+		{"k=v", 0, zeroTime},
+		{"k=v; Max-Age=60", 60, zeroTime},
+		{"k=v; max-age=60", 60, zeroTime},
+		{"k=v; MAX-AGE=60", 60, zeroTime},
+		{"k=v; Max-Age=0", 0, CookieExpireDelete},
+		{"k=v; Max-Age=-1", 0, CookieExpireDelete},
+		{"k=v; Max-Age=60; Max-Age=30", 30, zeroTime},
+
+		// 值无效:忽略该属性,cookie仍有效
+		{"k=v; Max-Age=", 0, zeroTime},
+		{"k=v; Max-Age=abc", 0, zeroTime},
+		{"k=v; Max-Age=1a", 0, zeroTime},
+		{"k=v; Max-Age=-", 0, zeroTime},
+		{"k=v; Max-Age=+5", 0, zeroTime},
+		{"k=v; Max-Age=60; Max-Age=x", 60, zeroTime},
+
+		// 过大的值被截断
+		{"k=v; Max-Age=99999999999999999999999", cookieMaxAgeLimit, zeroTime},
+		{"k=v; Max-Age=-99999999999999999999999", 0, CookieExpireDelete},
+
+		// Max-Age优先于expires,与顺序无关
+		{"k=v; Max-Age=0; " + expires, 0, CookieExpireDelete},
+		{"k=v; " + expires + "; Max-Age=0", 0, CookieExpireDelete},
+		{"k=v; Max-Age=60; " + expires, 60, expire},
+		{"k=v; " + expires + "; Max-Age=60", 60, expire},
+		{"k=v; Max-Age=bad; " + expires, 0, expire},
+	} {
+		var c Cookie
+		if err := c.Parse(tc.src); err != nil {
+			t.Errorf("%q: unexpected error: %s", tc.src, err)
+			continue
+		}
+		if string(c.Key()) != "k" || string(c.Value()) != "v" {
+			t.Errorf("%q: unexpected cookie %q=%q", tc.src, c.Key(), c.Value())
+		}
+		if c.MaxAge() != tc.maxAge {
+			t.Errorf("%q: unexpected MaxAge %d, want %d", tc.src, c.MaxAge(), tc.maxAge)
+		}
+		if !c.expire.Equal(tc.expire) {
+			t.Errorf("%q: unexpected expire %v, want %v", tc.src, c.expire, tc.expire)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package selfFastHttp
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 客户端cookie存储,按RFC 6265保存、匹配cookie
+// SaveResponse保存响应的Set-Cookie,AddToRequest向请求添加匹配的cookie:
+//
+//	jar.AddToRequest(req)
+//	err := c.Do(req, resp)
+//	jar.SaveResponse(req.URI(), resp)
+//
+// Secure的cookie:仅由https响应设置,仅发往https
+// HttpOnly的cookie:随请求发送，但不由Cookies返回(非http接口)
+// Domain属性须为请求host或其父域,且含'.'(拒绝"com"等)
+// 不使用公共后缀列表:公共后缀不被拒绝,如foo.co.uk可为"co.uk"设置cookie,该cookie将发往所有*.co.uk
+// 不可复制;可在多个协程中并发使用
+type CookieJar struct {
+	noCopy noCopy
+
+	mu      sync.Mutex
+	entries map[string][]*jarEntry // 域 -> cookie
+}
+
+type jarEntry struct {
+	cookie   Cookie
+	hostOnly bool      // 未设置Domain属性,仅发往设置它的host
+	expires  time.Time // 零值:会话cookie,不过期
+	created  time.Time
+}
+
+// 保存resp中的Set-Cookie,uri为请求的url
+// 过期的cookie(如Max-Age=0)删除已保存的同名cookie
+// 格式错误、domain不匹配的cookie被忽略
+func (j *CookieJar) SaveResponse(uri *URI, resp *Response) {
+	host := jarHost(uri.Host())
+	if len(host) == 0 {
+		return
+	}
+	isHTTPS := bytes.Equal(uri.Scheme(), strHTTPS)
+	now := time.Now()
+
+	c := AcquireCookie()
+	j.mu.Lock()
+	resp.Header.VisitAllCookie(func(key, value []byte) {
+		if err := c.ParseBytes(value); err != nil {
+			return
+		}
+		j.setLocked(c, host, uri.Path(), isHTTPS, now)
+	})
+	j.mu.Unlock()
+	ReleaseCookie(c)
+}
+
+// 将与req的url匹配的cookie按RFC 6265 5.4的顺序添加到req:路径长的在前，路径相同时先创建的在前
+// 同名、不同路径的cookie均添加;req中已有的cookie不被覆盖,同名的不再添加
+func (j *CookieJar) AddToRequest(req *Request) {
+	uri := req.URI()
+	h := &req.Header
+	h.parseRawHeaders()
+	h.collectCookies()
+	n := len(h.cookies) // 调用者已设置的cookie
+
+	j.mu.Lock()
+	entries := j.matchLocked(uri, true, time.Now())
+	for _, e := range entries {
+		if hasArg(h.cookies[:n], b2s(e.cookie.Key())) {
+			continue
+		}
+		h.cookies = appendArgBytes(h.cookies, e.cookie.Key(), e.cookie.Value())
+	}
+	j.mu.Unlock()
+}
+
+// 返回与uri匹配的cookie的副本,不含HttpOnly的
+// 用完后，可用ReleaseCookie还回池中
+func (j *CookieJar) Cookies(uri *URI) []*Cookie {
+	j.mu.Lock()
+	entries := j.matchLocked(uri, false, time.Now())
+	cookies := make([]*Cookie, 0, len(entries))
+	for _, e := range entries {
+		c := AcquireCookie()
+		e.cookie.CopyTo(c)
+		cookies = append(cookies, c)
+	}
+	j.mu.Unlock()
+	return cookies
+}
+
+// 移除所有cookie
+func (j *CookieJar) Reset() {
+	j.mu.Lock()
+	j.entries = nil
+	j.mu.Unlock()
+}
+
+// 按RFC 6265 5.3保存c
+func (j *CookieJar) setLocked(c *Cookie, host string, reqPath []byte, isHTTPS bool, now time.Time) {
+	if len(c.Key()) == 0 {
+		return
+	}
+	if c.Secure() && !isHTTPS {
+		return
+	}
+
+	domain, hostOnly, ok := jarDomain(host, string(c.Domain()))
+	if !ok {
+		return
+	}
+
+	path := c.Path()
+	if len(path) == 0 || path[0] != '/' {
+		path = jarDefaultPath(reqPath)
+	}
+
+	var expires time.Time
+	if maxAge := c.MaxAge(); maxAge > 0 {
+		if maxAge > cookieMaxAgeLimit {
+			maxAge = cookieMaxAgeLimit
+		}
+		expires = now.Add(time.Duration(maxAge) * time.Second)
+	} else if !c.Expire().IsZero() {
+		expires = c.Expire()
+	}
+
+	es := j.entries[domain]
+	for i, e := range es {
+		if bytes.Equal(e.cookie.Key(), c.Key()) && bytes.Equal(e.cookie.Path(), path) {
+			if !expires.IsZero() && !expires.After(now) { // 删除
+				j.entries[domain] = append(es[:i], es[i+1:]...)
+				return
+			}
+			j.fillEntry(e, c, path, hostOnly, expires)
+			return
+		}
+	}
+	if !expires.IsZero() && !expires.After(now) {
+		return
+	}
+
+	e := &jarEntry{created: now}
+	j.fillEntry(e, c, path, hostOnly, expires)
+	if j.entries == nil {
+		j.entries = make(map[string][]*jarEntry)
+	}
+	j.entries[domain] = append(es, e)
+}
+
+func (j *CookieJar) fillEntry(e *jarEntry, c *Cookie, path []byte, hostOnly bool, expires time.Time) {
+	c.CopyTo(&e.cookie)
+	e.cookie.path = append(e.cookie.path[:0], path...)
+	e.hostOnly = hostOnly
+	e.expires = expires
+}
+
+// 返回与uri匹配的cookie,移除已过期的
+// 按路径长度降序、创建时间升序
+func (j *CookieJar) matchLocked(uri *URI, includeHTTPOnly bool, now time.Time) []*jarEntry {
+	host := jarHost(uri.Host())
+	if len(host) == 0 || len(j.entries) == 0 {
+		return nil
+	}
+	isHTTPS := bytes.Equal(uri.Scheme(), strHTTPS)
+	path := uri.Path()
+
+	var matched []*jarEntry
+	// host本身及其各级父域
+	for domain := host; ; {
+		es := j.entries[domain]
+		n := 0
+		for _, e := range es {
+			if !e.expires.IsZero() && !e.expires.After(now) {
+				continue
+			}
+			es[n] = e
+			n++
+			if e.hostOnly && domain != host {
+				continue
+			}
+			if e.cookie.Secure() && !isHTTPS {
+				continue
+			}
+			if e.cookie.HTTPOnly() && !includeHTTPOnly {
+				continue
+			}
+			if !jarPathMatch(path, e.cookie.Path()) {
+				continue
+			}
+			matched = append(matched, e)
+		}
+		if n < len(es) {
+			for i := n; i < len(es); i++ {
+				es[i] = nil
+			}
+			if n == 0 {
+				delete(j.entries, domain)
+			} else {
+				j.entries[domain] = es[:n]
+			}
+		}
+
+		if net.ParseIP(host) != nil {
+			break
+		}
+		i := strings.IndexByte(domain, '.')
+		if i < 0 {
+			break
+		}
+		domain = domain[i+1:]
+	}
+
+	sort.SliceStable(matched, func(a, b int) bool {
+		pa, pb := len(matched[a].cookie.Path()), len(matched[b].cookie.Path())
+		if pa != pb {
+			return pa > pb
+		}
+		return matched[a].created.Before(matched[b].created)
+	})
+	return matched
+}
+
+// 去掉端口、ipv6的[],小写
+func jarHost(host []byte) string {
+	h := string(host)
+	if hh, _, err := net.SplitHostPort(h); err == nil {
+		h = hh
+	}
+	h = strings.TrimSuffix(strings.TrimPrefix(h, "["), "]")
+	return strings.ToLower(h)
+}
+
+// 按Domain属性确定cookie的域
+// 返回ok为false:domain与host不匹配,或不含'.'
+func jarDomain(host, domain string) (string, bool, bool) {
+	domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+	if len(domain) == 0 {
+		return host, true, true
+	}
+	if domain == host {
+		return domain, false, true
+	}
+	if net.ParseIP(host) != nil {
+		return "", false, false
+	}
+	if strings.IndexByte(domain, '.') < 0 || !strings.HasSuffix(host, "."+domain) {
+		return "", false, false
+	}
+	return domain, false, true
+}
+
+// RFC 6265 5.1.4:请求路径最后一个'/'之前的部分
+func jarDefaultPath(reqPath []byte) []byte {
+	if len(reqPath) == 0 || reqPath[0] != '/' {
+		return strSlash
+	}
+	n := bytes.LastIndexByte(reqPath, '/')
+	if n == 0 {
+		return strSlash
+	}
+	return reqPath[:n]
+}
+
+// RFC 6265 5.1.4:path与cookiePath相同，或以cookiePath开头且在'/'处分隔
+func jarPathMatch(path, cookiePath []byte) bool {
+	if !bytes.HasPrefix(path, cookiePath) {
+		return false
+	}
+	return len(path) == len(cookiePath) ||
+		cookiePath[len(cookiePath)-1] == '/' ||
+		path[len(cookiePath)] == '/'
+}
@@ -0,0 +1,178 @@
+package selfFastHttp
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestJarResponse(t *testing.T, setCookies ...string) *Response {
+	raw := "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n"
+	for _, sc := range setCookies {
+		raw += "Set-Cookie: " + sc + "\r\n"
+	}
+	var resp Response
+	if err := resp.Read(bufio.NewReader(strings.NewReader(raw + "\r\n"))); err != nil {
+		t.Fatal(err)
+	}
+	return &resp
+}
+
+func testJarSave(t *testing.T, j *CookieJar, url string, setCookies ...string) {
+	var uri URI
+	uri.Parse(nil, []byte(url))
+	j.SaveResponse(&uri, newTestJarResponse(t, setCookies...))
+}
+
+// 返回AddToRequest后req中的cookie,如"a=1; b=2"
+func testJarRequestCookies(j *CookieJar, req *Request) string {
+	j.AddToRequest(req)
+	var parts []string
+	req.Header.VisitAllCookie(func(k, v []byte) {
+		parts = append(parts, string(k)+"="+string(v))
+	})
+	return strings.Join(parts, "; ")
+}
+
+func TestCookieJarMatch(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		setURL    string
+		setCookie string
+		reqURL    string
+		want      string
+	}{
+		{"host only", "http://example.com/", "a=1", "http://example.com/", "a=1"},
+		{"host only subdomain", "http://example.com/", "a=1", "http://www.example.com/", ""},
+		{"host case and port", "http://Example.COM:8080/", "a=1", "http://example.com/", "a=1"},
+		{"domain", "http://www.example.com/", "a=1; Domain=example.com", "http://foo.example.com/", "a=1"},
+		{"domain leading dot", "http://www.example.com/", "a=1; Domain=.EXAMPLE.com", "http://example.com/", "a=1"},
+		{"domain other", "http://www.example.com/", "a=1; Domain=example.org", "http://example.org/", ""},
+		{"domain child", "http://example.com/", "a=1; Domain=www.example.com", "http://www.example.com/", ""},
+		{"domain no dot", "http://www.example.com/", "a=1; Domain=com", "http://other.com/", ""},
+		{"domain suffix not on dot", "http://badexample.com/", "a=1; Domain=example.com", "http://example.com/", ""},
+		{"public suffix accepted", "http://foo.co.uk/", "a=1; Domain=co.uk", "http://bar.co.uk/", "a=1"},
+		{"ip", "http://127.0.0.1/", "a=1", "http://127.0.0.1/", "a=1"},
+		{"ip domain", "http://127.0.0.1/", "a=1; Domain=0.0.1", "http://127.0.0.1/", ""},
+
+		{"path", "http://example.com/", "a=1; Path=/x", "http://example.com/x/y", "a=1"},
+		{"path exact", "http://example.com/", "a=1; Path=/x", "http://example.com/x", "a=1"},
+		{"path other", "http://example.com/", "a=1; Path=/x", "http://example.com/y", ""},
+		{"path prefix not on slash", "http://example.com/", "a=1; Path=/x", "http://example.com/xy", ""},
+		{"path trailing slash", "http://example.com/", "a=1; Path=/x/", "http://example.com/x/y", "a=1"},
+		{"default path", "http://example.com/x/y", "a=1", "http://example.com/x/z", "a=1"},
+		{"default path other", "http://example.com/x/y", "a=1", "http://example.com/z", ""},
+		{"relative path", "http://example.com/x/y", "a=1; Path=z", "http://example.com/x", "a=1"},
+
+		{"secure https", "https://example.com/", "a=1; Secure", "https://example.com/", "a=1"},
+		{"secure http", "https://example.com/", "a=1; Secure", "http://example.com/", ""},
+		{"secure set over http", "http://example.com/", "a=1; Secure", "https://example.com/", ""},
+		{"httponly", "http://example.com/", "a=1; HttpOnly", "http://example.com/", "a=1"},
+
+		{"max-age", "http://example.com/", "a=1; Max-Age=60", "http://example.com/", "a=1"},
+		{"max-age zero", "http://example.com/", "a=1; Max-Age=0", "http://example.com/", ""},
+		{"max-age huge", "http://example.com/", "a=1; Max-Age=99999999999999999999", "http://example.com/", "a=1"},
+		{"expires past", "http://example.com/", "a=1; Expires=Tue, 10 Nov 2009 23:00:00 GMT", "http://example.com/", ""},
+		{"expires future", "http://example.com/", "a=1; Expires=Fri, 01 Jan 2100 00:00:00 GMT", "http://example.com/", "a=1"},
+		{"max-age over expires", "http://example.com/", "a=1; Max-Age=60; Expires=Tue, 10 Nov 2009 23:00:00 GMT", "http://example.com/", "a=1"},
+		{"no key", "http://example.com/", "=1", "http://example.com/", ""},
+	} {
+		var j CookieJar
+		testJarSave(t, &j, tc.setURL, tc.setCookie)
+		var req Request
+		req.SetRequestURI(tc.reqURL)
+		if s := testJarRequestCookies(&j, &req); s != tc.want {
+			t.Errorf("%s: got cookies %q, want %q", tc.name, s, tc.want)
+		}
+	}
+}
+
+func TestCookieJarExpiry(t *testing.T) {
+	const url = "http://example.com/"
+	for _, tc := range []struct {
+		name       string
+		setCookies []string
+		want       string
+	}{
+		{"replace", []string{"a=1", "a=2"}, "a=2"},
+		{"delete by max-age", []string{"a=1; Max-Age=60", "a=1; Max-Age=0"}, ""},
+		{"delete by negative max-age", []string{"a=1", "a=1; Max-Age=-1"}, ""},
+		{"delete by expires", []string{"a=1", "a=1; Expires=Tue, 10 Nov 2009 23:00:00 GMT"}, ""},
+		{"delete max-age before expires", []string{"a=1", "a=1; Max-Age=0; Expires=Fri, 01 Jan 2100 00:00:00 GMT"}, ""},
+		{"delete other path", []string{"a=1; Path=/", "a=1; Path=/x; Max-Age=0"}, "a=1"},
+		{"invalid max-age ignored", []string{"a=1; Max-Age=abc"}, "a=1"},
+	} {
+		var j CookieJar
+		for _, sc := range tc.setCookies {
+			testJarSave(t, &j, url, sc)
+		}
+		var req Request
+		req.SetRequestURI(url)
+		if s := testJarRequestCookies(&j, &req); s != tc.want {
+			t.Errorf("%s: got cookies %q, want %q", tc.name, s, tc.want)
+		}
+	}
+
+	// 到期后不再发送,并被移除
+	var j CookieJar
+	testJarSave(t, &j, url, "a=1; Max-Age=60", "b=2")
+	var uri URI
+	uri.Parse(nil, []byte(url))
+	j.mu.Lock()
+	matched := j.matchLocked(&uri, true, time.Now().Add(time.Minute+time.Second))
+	n := len(j.entries["example.com"])
+	j.mu.Unlock()
+	if len(matched) != 1 || string(matched[0].cookie.Key()) != "b" {
+		t.Fatalf("unexpected cookies after expiry: %d", len(matched))
+	}
+	if n != 1 {
+		t.Fatalf("expired cookie has not been removed: %d entries", n)
+	}
+}
+
+func TestCookieJarAddToRequest(t *testing.T) {
+	var j CookieJar
+	testJarSave(t, &j, "http://www.example.com/", "a=root; Path=/", "b=2; Path=/")
+	time.Sleep(time.Millisecond) // created不同:路径长度相同时，先创建的在前
+	testJarSave(t, &j, "http://www.example.com/", "c=3; Domain=example.com")
+	testJarSave(t, &j, "http://www.example.com/", "a=deep; Path=/x/y", "a=mid; Path=/x")
+
+	for _, tc := range []struct {
+		name   string
+		url    string
+		cookie [][2]string // 调用者已设置的cookie
+		want   string
+	}{
+		{"all in order", "http://www.example.com/x/y/z", nil, "a=deep; a=mid; a=root; b=2; c=3"},
+		{"path subset", "http://www.example.com/x", nil, "a=mid; a=root; b=2; c=3"},
+		{"parent domain", "http://example.com/x/y", nil, "c=3"},
+		{"caller cookie kept", "http://www.example.com/x/y", [][2]string{{"a", "mine"}}, "a=mine; b=2; c=3"},
+		{"caller other cookie", "http://www.example.com/", [][2]string{{"z", "9"}}, "z=9; a=root; b=2; c=3"},
+	} {
+		var req Request
+		req.SetRequestURI(tc.url)
+		for _, kv := range tc.cookie {
+			req.Header.SetCookie(kv[0], kv[1])
+		}
+		if s := testJarRequestCookies(&j, &req); s != tc.want {
+			t.Errorf("%s: got cookies %q, want %q", tc.name, s, tc.want)
+		}
+	}
+}
+
+func TestCookieJarCookies(t *testing.T) {
+	var j CookieJar
+	testJarSave(t, &j, "https://example.com/", "a=1", "b=2; HttpOnly", "c=3; Secure")
+	var uri URI
+	uri.Parse(nil, []byte("https://example.com/"))
+	cookies := j.Cookies(&uri)
+	var keys []string
+	for _, c := range cookies {
+		keys = append(keys, string(c.Key()))
+		ReleaseCookie(c)
+	}
+	if s := strings.Join(keys, ","); s != "a,c" {
+		t.Fatalf("unexpected cookies %q, want %q", s, "a,c")
+	}
+}
@@ -0,0 +1,275 @@
+//go:build !windows
+// +build !windows
+
+package selfFastHttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 平滑重启:父进程将监听socket传给新启动的子进程(如升级后的程序)，子进程就绪后，父进程Shutdown并退出
+// 期间监听socket一直打开，不拒绝任何连接
+// 亦支持systemd的socket activation(LISTEN_FDS)
+//
+// 父进程:
+//
+//	ln, err := ListenInherited("tcp4", addr)
+//	go s.Serve(ln)
+//	// 收到重启信号后
+//	if _, err := StartChildProcess(ctx, ln); err == nil {
+//		s.Shutdown(ctx) // 处理完已有连接后退出
+//	}
+//
+// 子进程为同一程序,ListenInherited返回继承的监听器;开始Serve后，调用NotifyParentReady
+// 仅支持unix系统
+
+const (
+	envInheritFDs = "SELFFASTHTTP_INHERIT_FDS" // 继承的监听器数
+	envReadyFD    = "SELFFASTHTTP_READY_FD"    // 通知父进程就绪的管道fd
+
+	envListenPID     = "LISTEN_PID" // systemd socket activation
+	envListenFDs     = "LISTEN_FDS"
+	envListenFDNames = "LISTEN_FDNAMES"
+
+	listenFDsStart = 3 // 继承的fd起始值,0-2为标准输入、输出、错误
+)
+
+var (
+	inheritOnce sync.Once
+	inheritLock sync.Mutex
+	inherited   []net.Listener // 未被取走的继承的监听器
+	inheritErr  error
+	readyFD     int // 0:无父进程等待就绪通知
+)
+
+var errNoReadyFD = errors.New("the process was not started by StartChildProcess")
+
+// 返回从父进程或systemd继承、且尚未被ListenInherited取走的监听器
+// 无继承时，返回空
+func InheritedListeners() ([]net.Listener, error) {
+	inheritOnce.Do(loadInheritedListeners)
+	inheritLock.Lock()
+	lns := append([]net.Listener(nil), inherited...)
+	inheritLock.Unlock()
+	return lns, inheritErr
+}
+
+// 有继承的监听器的地址与network,addr相同时，返回之;否则新建监听器
+func ListenInherited(network, addr string) (net.Listener, error) {
+	inheritOnce.Do(loadInheritedListeners)
+	if inheritErr != nil {
+		return nil, inheritErr
+	}
+	inheritLock.Lock()
+	for i, ln := range inherited {
+		if listenerAddrMatch(ln.Addr(), network, addr) {
+			inherited = append(inherited[:i], inherited[i+1:]...)
+			inheritLock.Unlock()
+			return ln, nil
+		}
+	}
+	inheritLock.Unlock()
+	return net.Listen(network, addr)
+}
+
+// 通知父进程:子进程已开始服务，父进程可以退出了
+// 须在继承的监听器开始Serve后调用
+func NotifyParentReady() error {
+	inheritOnce.Do(loadInheritedListeners)
+	inheritLock.Lock()
+	fd := readyFD
+	readyFD = 0
+	inheritLock.Unlock()
+	if fd == 0 {
+		return errNoReadyFD
+	}
+	f := os.NewFile(uintptr(fd), "ready")
+	_, err := f.Write([]byte{1})
+	f.Close()
+	return err
+}
+
+// 以当前程序(os.Executable)及参数启动子进程，将lns传给子进程
+// 等待子进程调用NotifyParentReady;子进程提前退出或ctx结束时，杀掉子进程，返回错误
+// 父进程的lns保持打开，调用者在返回nil后Shutdown
+// lns为net.Listen返回的TCP/UNIX监听器，或封装了它们的ProxyProtocolListener
+func StartChildProcess(ctx context.Context, lns ...net.Listener) (*os.Process, error) {
+	files := make([]*os.File, 0, len(lns))
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, ln := range lns {
+		f, err := listenerFile(ln)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+
+	path, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("cannot find executable: %s", err)
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	env := make([]string, 0, len(os.Environ())+2)
+	for _, kv := range os.Environ() {
+		switch kv[:strings.IndexByte(kv+"=", '=')] {
+		case envInheritFDs, envReadyFD, envListenPID, envListenFDs, envListenFDNames:
+		default:
+			env = append(env, kv)
+		}
+	}
+	env = append(env,
+		envInheritFDs+"="+strconv.Itoa(len(files)),
+		envReadyFD+"="+strconv.Itoa(listenFDsStart+len(files)))
+
+	procFiles := append([]*os.File{os.Stdin, os.Stdout, os.Stderr}, files...)
+	procFiles = append(procFiles, w)
+	p, err := os.StartProcess(path, os.Args, &os.ProcAttr{
+		Env:   env,
+		Files: procFiles,
+	})
+	w.Close()
+	if err != nil {
+		return nil, fmt.Errorf("cannot start child process: %s", err)
+	}
+
+	readyCh := make(chan error, 1)
+	go func() {
+		var b [1]byte
+		_, err := r.Read(b[:])
+		readyCh <- err
+	}()
+	select {
+	case err = <-readyCh:
+		if err == nil {
+			keepUnixSockets(lns)
+			return p, nil
+		}
+		err = fmt.Errorf("child process exited before ready: %s", err)
+	case <-ctx.Done():
+		err = fmt.Errorf("child process is not ready: %s", ctx.Err())
+	}
+	p.Kill()
+	p.Wait()
+	return nil, err
+}
+
+// 取监听器的fd副本
+func listenerFile(ln net.Listener) (*os.File, error) {
+	if pln, ok := ln.(*ProxyProtocolListener); ok {
+		ln = pln.Listener
+	}
+	switch x := ln.(type) {
+	case *net.TCPListener:
+		return x.File()
+	case *net.UnixListener:
+		return x.File()
+	}
+	return nil, fmt.Errorf("cannot pass listener %T to child process", ln)
+}
+
+// 子进程就绪后调用:父进程关闭unix socket监听器时，不删除子进程仍在使用的socket文件
+// 子进程启动失败时不调用，父进程照常删除
+func keepUnixSockets(lns []net.Listener) {
+	for _, ln := range lns {
+		if pln, ok := ln.(*ProxyProtocolListener); ok {
+			ln = pln.Listener
+		}
+		if x, ok := ln.(*net.UnixListener); ok {
+			x.SetUnlinkOnClose(false)
+		}
+	}
+}
+
+// 读取继承的fd:先父进程(StartChildProcess),再systemd
+// 读取后，移除环境变量，以免再传给本进程的子进程
+func loadInheritedListeners() {
+	n, err := inheritedFDCount()
+	if err != nil {
+		inheritErr = err
+		return
+	}
+	for i := 0; i < n; i++ {
+		fd := listenFDsStart + i
+		f := os.NewFile(uintptr(fd), "listener")
+		ln, err := net.FileListener(f) // 复制fd
+		f.Close()
+		if err != nil {
+			inheritErr = fmt.Errorf("cannot use inherited fd %d as listener: %s", fd, err)
+			return
+		}
+		inherited = append(inherited, ln)
+	}
+}
+
+func inheritedFDCount() (int, error) {
+	if v := os.Getenv(envInheritFDs); len(v) > 0 {
+		os.Unsetenv(envInheritFDs)
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid %s=%q", envInheritFDs, v)
+		}
+		if v = os.Getenv(envReadyFD); len(v) > 0 {
+			os.Unsetenv(envReadyFD)
+			if readyFD, err = strconv.Atoi(v); err != nil {
+				return 0, fmt.Errorf("invalid %s=%q", envReadyFD, v)
+			}
+		}
+		return n, nil
+	}
+
+	v := os.Getenv(envListenFDs)
+	if len(v) == 0 {
+		return 0, nil
+	}
+	pid := os.Getenv(envListenPID)
+	os.Unsetenv(envListenPID)
+	os.Unsetenv(envListenFDs)
+	os.Unsetenv(envListenFDNames)
+	if pid != strconv.Itoa(os.Getpid()) { // 传给其它进程的
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s=%q", envListenFDs, v)
+	}
+	return n, nil
+}
+
+// 检测监听器地址a是否为network,addr
+func listenerAddrMatch(a net.Addr, network, addr string) bool {
+	switch network {
+	case "unix":
+		return a.Network() == "unix" && a.String() == addr
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return false
+	}
+	la, ok := a.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	ra, err := net.ResolveTCPAddr(network, addr)
+	if err != nil || la.Port != ra.Port {
+		return false
+	}
+	if len(ra.IP) == 0 || ra.IP.IsUnspecified() {
+		return la.IP.IsUnspecified()
+	}
+	return la.IP.Equal(ra.IP)
+}
@@ -0,0 +1,115 @@
+//go:build !windows
+// +build !windows
+
+package selfFastHttp
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/forTWOS/selfFastHttp/selffasthttputil"
+)
+
+func TestInheritedFDCount(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+	for _, tc := range []struct {
+		name    string
+		env     map[string]string
+		n       int
+		readyFD int
+		err     bool
+	}{
+		{"none", nil, 0, 0, false},
+		{"parent", map[string]string{envInheritFDs: "2", envReadyFD: "7"}, 2, 7, false},
+		{"parent without ready fd", map[string]string{envInheritFDs: "1"}, 1, 0, false},
+		{"parent negative", map[string]string{envInheritFDs: "-1"}, 0, 0, true},
+		{"parent invalid ready fd", map[string]string{envInheritFDs: "1", envReadyFD: "x"}, 0, 0, true},
+		{"systemd", map[string]string{envListenFDs: "3", envListenPID: pid}, 3, 0, false},
+		{"systemd other pid", map[string]string{envListenFDs: "3", envListenPID: "1"}, 0, 0, false},
+		{"systemd invalid", map[string]string{envListenFDs: "x", envListenPID: pid}, 0, 0, true},
+		// 优先使用父进程传来的
+		{"parent and systemd", map[string]string{envInheritFDs: "1", envListenFDs: "3", envListenPID: pid}, 1, 0, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, k := range []string{envInheritFDs, envReadyFD, envListenPID, envListenFDs, envListenFDNames} {
+				t.Setenv(k, tc.env[k])
+				if len(tc.env[k]) == 0 {
+					os.Unsetenv(k)
+				}
+			}
+			savedReadyFD := readyFD
+			readyFD = 0
+			defer func() { readyFD = savedReadyFD }()
+
+			n, err := inheritedFDCount()
+			if (err != nil) != tc.err {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if tc.err {
+				return
+			}
+			if n != tc.n || readyFD != tc.readyFD {
+				t.Fatalf("unexpected n=%d readyFD=%d, want n=%d readyFD=%d", n, readyFD, tc.n, tc.readyFD)
+			}
+			if v := os.Getenv(envInheritFDs); len(v) > 0 {
+				t.Fatalf("%s is not removed", envInheritFDs)
+			}
+		})
+	}
+}
+
+func TestListenerAddrMatch(t *testing.T) {
+	tcp := func(s string) net.Addr {
+		a, err := net.ResolveTCPAddr("tcp", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return a
+	}
+	unix := &net.UnixAddr{Name: "/tmp/a.sock", Net: "unix"}
+	for _, tc := range []struct {
+		a       net.Addr
+		network string
+		addr    string
+		ok      bool
+	}{
+		{tcp("127.0.0.1:8080"), "tcp4", "127.0.0.1:8080", true},
+		{tcp("127.0.0.1:8080"), "tcp", "127.0.0.1:8081", false},
+		{tcp("127.0.0.1:8080"), "tcp4", ":8080", false},
+		{tcp("0.0.0.0:8080"), "tcp4", ":8080", true},
+		{tcp("0.0.0.0:8080"), "tcp4", "0.0.0.0:8080", true},
+		{tcp("0.0.0.0:8080"), "tcp4", "127.0.0.1:8080", false},
+		{tcp("[::1]:8080"), "tcp6", "[::1]:8080", true},
+		{tcp("127.0.0.1:8080"), "udp", "127.0.0.1:8080", false},
+		{tcp("127.0.0.1:8080"), "unix", "127.0.0.1:8080", false},
+		{unix, "unix", "/tmp/a.sock", true},
+		{unix, "unix", "/tmp/b.sock", false},
+		{unix, "tcp", "/tmp/a.sock", false},
+	} {
+		if ok := listenerAddrMatch(tc.a, tc.network, tc.addr); ok != tc.ok {
+			t.Errorf("listenerAddrMatch(%s, %q, %q)=%v, want %v", tc.a, tc.network, tc.addr, ok, tc.ok)
+		}
+	}
+}
+
+// 未能启动子进程时，unix socket文件照常随监听器关闭而删除
+func TestStartChildProcessErrorUnlinksUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := selffasthttputil.NewInmemoryListener()
+	defer other.Close()
+	if _, err := StartChildProcess(context.Background(), ln, other); err == nil {
+		t.Fatalf("expecting error for %T", other)
+	}
+	ln.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("socket file is not removed: %v", err)
+	}
+}
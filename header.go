@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"sync/atomic"
 	"time"
 )
@@ -430,9 +431,11 @@ func (h *RequestHeader) Method() []byte {
 
 func (h *RequestHeader) SetMethod(method string) {
 	h.method = append(h.method[:0], method...)
+	h.isGet = false // IsGet重新判断
 }
 func (h *RequestHeader) SetMethodBytes(method []byte) {
 	h.method = append(h.method[:0], method...)
+	h.isGet = false // IsGet重新判断
 }
 
 // --- Req.RequestURI
@@ -1110,6 +1113,11 @@ func headerError(typ string, err, errParse error, b []byte) error {
 		return io.EOF
 	}
 
+	if isTimeoutError(err) {
+		return &ErrReadTimeout{
+			error: headerErrorMsg(typ, err, b),
+		}
+	}
 	if err != bufio.ErrBufferFull {
 		return headerErrorMsg(typ, err, b)
 	}
@@ -1159,6 +1167,11 @@ func (h *RequestHeader) tryRead(r *bufio.Reader, n int) error {
 			}
 		}
 
+		if isTimeoutError(err) {
+			return &ErrReadTimeout{
+				error: fmt.Errorf("error when reading request headers: %s", err),
+			}
+		}
 		return fmt.Errorf("error when reading request headers: %s", err)
 	}
 	b = mustPeekBuffered(r)
@@ -1919,6 +1932,24 @@ type ErrSmallBuffer struct {
 	error
 }
 
+// 读取超时
+// Server读取请求时，见Server.ReadTimeout,Server.HeaderReadTimeout,Server.MinRequestBodyRate
+type ErrReadTimeout struct {
+	error
+}
+
+// 请求格式错误:请求行、header、body等无法解析
+type ErrBadRequest struct {
+	error
+}
+
+// 检测err是否为网络读写超时
+// 含被包装的错误(%w)
+func isTimeoutError(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
 // 该函数确保取到值
 func mustPeekBuffered(r *bufio.Reader) []byte {
 	buf, err := r.Peek(r.Buffered())
@@ -0,0 +1,27 @@
+package selfFastHttp
+
+import "testing"
+
+func TestRequestHeaderSetMethodResetsIsGet(t *testing.T) {
+	var h RequestHeader
+	if !h.IsGet() {
+		t.Fatalf("default method must be GET")
+	}
+	for _, tc := range []struct {
+		method string
+		isGet  bool
+	}{
+		{"HEAD", false},
+		{"GET", true},
+		{"POST", false},
+	} {
+		h.SetMethod(tc.method)
+		if h.IsGet() != tc.isGet {
+			t.Errorf("SetMethod(%q): IsGet()=%v, want %v", tc.method, h.IsGet(), tc.isGet)
+		}
+		h.SetMethodBytes([]byte(tc.method))
+		if h.IsGet() != tc.isGet {
+			t.Errorf("SetMethodBytes(%q): IsGet()=%v, want %v", tc.method, h.IsGet(), tc.isGet)
+		}
+	}
+}
@@ -68,6 +68,43 @@ type Response struct {
 }
 
 // =================================
+// 从池中取Request
+// 用完后，用ReleaseRequest还回池中，减少gc
+func AcquireRequest() *Request {
+	v := requestPool.Get()
+	if v == nil {
+		return &Request{}
+	}
+	return v.(*Request)
+}
+
+// 还回的req不可再引用
+func ReleaseRequest(req *Request) {
+	req.Reset()
+	requestPool.Put(req)
+}
+
+// 从池中取Response
+// 用完后，用ReleaseResponse还回池中，减少gc
+func AcquireResponse() *Response {
+	v := responsePool.Get()
+	if v == nil {
+		return &Response{}
+	}
+	return v.(*Response)
+}
+
+// 还回的resp不可再引用
+func ReleaseResponse(resp *Response) {
+	resp.Reset()
+	responsePool.Put(resp)
+}
+
+var (
+	requestPool  sync.Pool
+	responsePool sync.Pool
+)
+
 // --- Req.Host
 func (req *Request) SetHost(host string) {
 	req.URI().SetHost(host)
@@ -731,6 +768,15 @@ func (req *Request) ReadLimitBody(r *bufio.Reader, maxBodySize int) error {
 func (req *Request) readLimitBody(r *bufio.Reader, maxBodySize int, getPostOnly bool) error {
 	// 不在此处reset请求，调用者需自己调用reset
 
+	if err := req.readHeader(r, getPostOnly); err != nil {
+		return err
+	}
+	return req.readLimitBodyAfterHeader(r, maxBodySize)
+}
+
+// 仅读取请求头部
+// Server分阶段读取请求时使用(头部、body各自的超时)
+func (req *Request) readHeader(r *bufio.Reader, getPostOnly bool) error {
 	err := req.Header.Read(r)
 	if err != nil {
 		return err
@@ -741,7 +787,11 @@ func (req *Request) readLimitBody(r *bufio.Reader, maxBodySize int, getPostOnly
 	if getPostOnly && !req.Header.IsGet() && !req.Header.IsPost() {
 		return errGetPostOnly
 	}
+	return nil
+}
 
+// 头部读取后，读取body
+func (req *Request) readLimitBodyAfterHeader(r *bufio.Reader, maxBodySize int) error {
 	if req.Header.noBody() { // HEAD GET方法
 		return nil
 	}
@@ -1517,14 +1567,14 @@ func parseChunkSize(r *bufio.Reader) (int, error) {
 	}
 	c, err := r.ReadByte() //取1字节
 	if err != nil {
-		return -1, fmt.Errorf("cannot read '\r' char at the end of chunk size: %s", err)
+		return -1, fmt.Errorf("cannot read '\r' char at the end of chunk size: %w", err)
 	}
 	if c != '\r' {
 		return -1, fmt.Errorf("unexpected char %q at the end of chunk size. Expected %q", c, '\r')
 	}
 	c, err = r.ReadByte() //取1字节
 	if err != nil {
-		return -1, fmt.Errorf("cannot read '\n' char at the end of chunk size: %s", err)
+		return -1, fmt.Errorf("cannot read '\n' char at the end of chunk size: %w", err)
 	}
 	if c != '\n' {
 		return -1, fmt.Errorf("unexpected char %q at the end of chunk size. Expected %q", c, '\n')
@@ -0,0 +1,278 @@
+package selfFastHttp
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LBClient的后端客户端
+// HostClient、PipelineClient均实现之
+type BalancingClient interface {
+	DoDeadline(req *Request, resp *Response, deadline time.Time) error
+	PendingRequests() int
+}
+
+// LBClient每个请求的默认超时时间
+const DefaultLBClientTimeout = time.Second
+
+// 后端连续失败该次数后，被摘除
+const DefaultLBClientMaxFails = 3
+
+// 探测已摘除后端的默认间隔
+const DefaultLBClientHealthCheckInterval = 5 * time.Second
+
+// 未设置Addrs、Clients
+var ErrNoBackends = errors.New("no backends configured for LBClient")
+
+// 多后端负载均衡客户端
+// 请求发往未摘除的后端中正在处理请求最少的一个
+// 后端连续失败MaxFails次后被摘除,此后每隔HealthCheckInterval用HealthCheckRequest探测,成功后恢复
+// 仅建立连接、连接读写出错或HealthCheck判定失败时计为后端失败,ErrTimeout等客户端自身的错误不计
+// 幂等请求(GET,HEAD,PUT,DELETE)失败时，在其它后端上重试
+// 所有后端均被摘除时，仍从中选择,不拒绝请求
+// 须用零值或指定参数创建，不可复制;首次使用后，不可再修改Addrs、Clients
+// 不再使用时，调用Close停止探测协程
+// 可在多个协程中并发使用
+type LBClient struct {
+	noCopy noCopy
+
+	// 后端地址,须含端口,如"10.0.0.1:8080"
+	// 每个地址使用一个HostClient
+	// 请求须设置Host头或包含完整url
+	Addrs []string
+
+	// 自定义的后端客户端,与Addrs合并使用
+	Clients []BalancingClient
+
+	// 每个请求(含重试)的超时时间,见Do
+	// 默认DefaultLBClientTimeout
+	Timeout time.Duration
+
+	// 判断后端是否正常处理了请求
+	// 返回false时，计为后端失败;幂等请求在其它后端上重试
+	// 默认:err为nil且响应码小于500
+	HealthCheck func(req *Request, resp *Response, err error) bool
+
+	// 后端连续失败该次数后，被摘除
+	// 默认DefaultLBClientMaxFails
+	MaxFails int
+
+	// 探测已摘除后端的请求,结果由HealthCheck判断
+	// 未设置Host时，使用后端地址
+	// 为nil时，摘除HealthCheckInterval后直接恢复
+	HealthCheckRequest *Request
+
+	// 探测已摘除后端的间隔
+	// 默认DefaultLBClientHealthCheckInterval
+	HealthCheckInterval time.Duration
+
+	once      sync.Once
+	backends  []*lbBackend
+	nextIdx   uint32 // 轮转起点,使负载相同的后端被均匀选择
+	stopCh    chan struct{}
+	closeOnce sync.Once
+
+	healthCheckLock sync.Mutex // HealthCheckRequest.CopyTo
+}
+
+type lbBackend struct {
+	c    BalancingClient
+	addr string // 探测请求的默认Host
+
+	fails   int32 // 连续失败次数
+	ejected int32 // 1:已摘除,由探测协程恢复
+}
+
+// 发送req,获取resp,超时时间为Timeout
+func (cc *LBClient) Do(req *Request, resp *Response) error {
+	return cc.DoDeadline(req, resp, time.Now().Add(cc.timeout()))
+}
+
+// 同Do,超时时间为timeout
+func (cc *LBClient) DoTimeout(req *Request, resp *Response, timeout time.Duration) error {
+	return cc.DoDeadline(req, resp, time.Now().Add(timeout))
+}
+
+// 同Do,在deadline前未完成时，返回ErrTimeout
+// 幂等请求失败时，在deadline前依次重试其它后端,每个后端最多一次
+func (cc *LBClient) DoDeadline(req *Request, resp *Response, deadline time.Time) error {
+	cc.once.Do(cc.init)
+	if len(cc.backends) == 0 {
+		return ErrNoBackends
+	}
+
+	isIdempotent := req.Header.IsGet() || req.Header.IsHead() || req.Header.IsPut() || req.Header.IsDelete()
+	var triedBuf [8]*lbBackend
+	tried := triedBuf[:0]
+	for {
+		b := cc.get(tried)
+		err := b.c.DoDeadline(req, resp, deadline)
+		ok := cc.isHealthy(req, resp, err)
+		if ok || err == nil || isLBBackendError(err) {
+			cc.report(b, ok)
+		}
+		if ok || !isIdempotent || err == ErrTimeout {
+			return err
+		}
+		tried = append(tried, b)
+		if len(tried) == len(cc.backends) || !time.Now().Before(deadline) {
+			return err
+		}
+	}
+}
+
+// 停止所有探测协程
+// 此后被摘除的后端不再恢复
+func (cc *LBClient) Close() {
+	cc.once.Do(cc.init)
+	cc.closeOnce.Do(func() { close(cc.stopCh) })
+}
+
+func (cc *LBClient) init() {
+	cc.stopCh = make(chan struct{})
+	for _, c := range cc.Clients {
+		cc.backends = append(cc.backends, &lbBackend{
+			c:    c,
+			addr: balancingClientAddr(c),
+		})
+	}
+	for _, addr := range cc.Addrs {
+		cc.backends = append(cc.backends, &lbBackend{
+			c:    &HostClient{Addr: addr},
+			addr: addr,
+		})
+	}
+}
+
+func balancingClientAddr(c BalancingClient) string {
+	switch x := c.(type) {
+	case *HostClient:
+		return x.Addr
+	case *PipelineClient:
+		return x.Addr
+	}
+	return ""
+}
+
+// 从未尝试过的后端中选择:优先未摘除的、正在处理请求最少的
+func (cc *LBClient) get(tried []*lbBackend) *lbBackend {
+	bs := cc.backends
+	n := uint32(len(bs))
+	idx := atomic.AddUint32(&cc.nextIdx, 1)
+
+	var minB, ejectedB *lbBackend
+	minReqs := 0
+	for i := uint32(0); i < n; i++ {
+		b := bs[(idx+i)%n]
+		if lbBackendTried(tried, b) {
+			continue
+		}
+		if atomic.LoadInt32(&b.ejected) != 0 {
+			if ejectedB == nil {
+				ejectedB = b
+			}
+			continue
+		}
+		reqs := b.c.PendingRequests()
+		if minB == nil || reqs < minReqs {
+			minB = b
+			minReqs = reqs
+		}
+	}
+	if minB == nil {
+		return ejectedB
+	}
+	return minB
+}
+
+func lbBackendTried(tried []*lbBackend, b *lbBackend) bool {
+	for _, t := range tried {
+		if t == b {
+			return true
+		}
+	}
+	return false
+}
+
+func (cc *LBClient) isHealthy(req *Request, resp *Response, err error) bool {
+	if cc.HealthCheck != nil {
+		return cc.HealthCheck(req, resp, err)
+	}
+	return err == nil && resp.StatusCode() < StatusInternalServerError
+}
+
+// err是否为后端连接的错误:建立连接失败、连接被关闭或读写出错
+// ErrTimeout、ErrNoFreeConns、ErrPipelineOverflow等客户端自身的错误，不计为后端失败
+func isLBBackendError(err error) bool {
+	switch err {
+	case ErrConnectionClosed, errPipelineConnStopped, io.EOF, io.ErrUnexpectedEOF:
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne)
+}
+
+// 记录后端处理结果,连续失败达MaxFails时摘除之，启动探测协程
+func (cc *LBClient) report(b *lbBackend, ok bool) {
+	if ok {
+		atomic.StoreInt32(&b.fails, 0)
+		return
+	}
+	maxFails := cc.MaxFails
+	if maxFails <= 0 {
+		maxFails = DefaultLBClientMaxFails
+	}
+	if atomic.AddInt32(&b.fails, 1) >= int32(maxFails) && atomic.CompareAndSwapInt32(&b.ejected, 0, 1) {
+		go cc.healthCheckLoop(b)
+	}
+}
+
+// 定期探测已摘除的后端,成功后恢复
+func (cc *LBClient) healthCheckLoop(b *lbBackend) {
+	interval := cc.HealthCheckInterval
+	if interval <= 0 {
+		interval = DefaultLBClientHealthCheckInterval
+	}
+	t := time.NewTimer(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-cc.stopCh:
+			return
+		}
+		if cc.HealthCheckRequest == nil || cc.probe(b) {
+			break
+		}
+		t.Reset(interval)
+	}
+	atomic.StoreInt32(&b.fails, 0)
+	atomic.StoreInt32(&b.ejected, 0)
+}
+
+func (cc *LBClient) probe(b *lbBackend) bool {
+	req := AcquireRequest()
+	resp := AcquireResponse()
+	cc.healthCheckLock.Lock()
+	cc.HealthCheckRequest.CopyTo(req)
+	cc.healthCheckLock.Unlock()
+	if len(req.Host()) == 0 && len(b.addr) > 0 {
+		req.SetHost(b.addr)
+	}
+	err := b.c.DoDeadline(req, resp, time.Now().Add(cc.timeout()))
+	ok := cc.isHealthy(req, resp, err)
+	ReleaseRequest(req)
+	ReleaseResponse(resp)
+	return ok
+}
+
+func (cc *LBClient) timeout() time.Duration {
+	if cc.Timeout > 0 {
+		return cc.Timeout
+	}
+	return DefaultLBClientTimeout
+}
@@ -0,0 +1,98 @@
+package selfFastHttp
+
+import (
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 返回固定错误的后端
+type testBalancingClient struct {
+	err   error
+	calls int32
+}
+
+func (c *testBalancingClient) DoDeadline(req *Request, resp *Response, deadline time.Time) error {
+	atomic.AddInt32(&c.calls, 1)
+	return c.err
+}
+
+func (c *testBalancingClient) PendingRequests() int {
+	return 0
+}
+
+// 幂等请求在其它后端上重试,连接出错的后端被摘除
+func TestLBClientRetry(t *testing.T) {
+	bad := &testBalancingClient{err: io.EOF}
+	good := &testBalancingClient{}
+	lbc := &LBClient{Clients: []BalancingClient{bad, good}, MaxFails: 1}
+	defer lbc.Close()
+
+	for i := 0; i < 4; i++ {
+		if err := lbc.Do(testPipelineRequest("/"), &Response{}); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	if n := atomic.LoadInt32(&bad.calls); n != 1 {
+		t.Fatalf("unexpected calls to ejected backend %d, want 1", n)
+	}
+
+	// 非幂等请求不重试
+	bad.err = io.ErrUnexpectedEOF
+	atomic.StoreInt32(&lbc.backends[0].ejected, 0)
+	req := testPipelineRequest("/")
+	req.Header.SetMethod("POST")
+	for atomic.LoadInt32(&bad.calls) == 1 {
+		if err := lbc.Do(req, &Response{}); err != nil && err != io.ErrUnexpectedEOF {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+}
+
+// 客户端自身的错误不计为后端失败
+func TestLBClientTimeoutIsNotBackendFailure(t *testing.T) {
+	for _, tc := range []struct {
+		err     error
+		ejected bool
+	}{
+		{ErrTimeout, false},
+		{ErrNoFreeConns, false},
+		{ErrPipelineOverflow, false},
+		{ErrConnectionClosed, true},
+		{io.EOF, true},
+	} {
+		lbc := &LBClient{Clients: []BalancingClient{&testBalancingClient{err: tc.err}}, MaxFails: 1}
+		lbc.Do(testPipelineRequest("/"), &Response{})
+		if ejected := atomic.LoadInt32(&lbc.backends[0].ejected) != 0; ejected != tc.ejected {
+			t.Errorf("%v: unexpected ejected %v, want %v", tc.err, ejected, tc.ejected)
+		}
+		lbc.Close()
+	}
+}
+
+// Close后探测协程退出
+func TestLBClientClose(t *testing.T) {
+	bad := &testBalancingClient{err: io.EOF}
+	lbc := &LBClient{
+		Clients:             []BalancingClient{bad},
+		MaxFails:            1,
+		HealthCheckRequest:  testPipelineRequest("/"),
+		HealthCheckInterval: 5 * time.Millisecond,
+	}
+	lbc.Do(testPipelineRequest("/"), &Response{})
+	for start := time.Now(); atomic.LoadInt32(&bad.calls) < 3; time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatalf("ejected backend is not probed")
+		}
+	}
+
+	lbc.Close()
+	time.Sleep(20 * time.Millisecond) // 等待进行中的探测结束
+	n := atomic.LoadInt32(&bad.calls)
+	time.Sleep(50 * time.Millisecond)
+	if n1 := atomic.LoadInt32(&bad.calls); n1 != n {
+		t.Fatalf("backend is still probed after Close: %d -> %d calls", n, n1)
+	}
+	lbc.Close()
+}
@@ -0,0 +1,47 @@
+package selfFastHttp
+
+import (
+	"net"
+	"time"
+)
+
+// 读取body时，最小速率的宽限时间
+const minRateGracePeriod = time.Second
+
+// 检测最小读取速率的连接读取器,见Server.MinRequestBodyRate
+// 内嵌于RequestCtx,位于statsConnIO与连接之间;未调用start时，直接读取连接
+type minRateReader struct {
+	c net.Conn
+
+	minRate  int       // 字节/秒,0:不检测
+	start    time.Time // 开始检测的时间
+	read     int       // 检测开始后读取的字节数
+	deadline time.Time // 读超时上限,zeroTime:无上限
+}
+
+// 开始检测读取速率:每次读取前，按已读字节数推算读超时
+// 平均速率不低于minRate时，读超时不断后延;停滞则超时
+func (r *minRateReader) startMinRate(minRate int, deadline time.Time) {
+	r.minRate = minRate
+	r.start = time.Now()
+	r.read = 0
+	r.deadline = deadline
+}
+
+func (r *minRateReader) stopMinRate() {
+	r.minRate = 0
+}
+
+func (r *minRateReader) Read(p []byte) (int, error) {
+	if r.minRate > 0 {
+		d := minRateGracePeriod + time.Duration(float64(r.read)/float64(r.minRate)*float64(time.Second))
+		deadline := r.start.Add(d)
+		if !r.deadline.IsZero() && r.deadline.Before(deadline) {
+			deadline = r.deadline
+		}
+		r.c.SetReadDeadline(deadline)
+	}
+	n, err := r.c.Read(p)
+	r.read += n
+	return n, err
+}
@@ -3,20 +3,22 @@ package selfFastHttp
 import (
 	"fmt"
 	"net"
+	"net/netip"
 	"sync"
+	"sync/atomic"
 )
 
 // 针对IP的连接计数器-协程锁
+// 按ip前缀聚合计数,见Server.MaxConnsPerIPv4Prefix、MaxConnsPerIPv6Prefix
 type perIPConnCounter struct {
-	pool sync.Pool // 连接池-封装连接-加入连接计数器
 	lock sync.Mutex
-	m    map[uint32]int // [ip]-count
+	m    map[netip.Prefix]int // [ip前缀]-count
 }
 
-func (cc *perIPConnCounter) Register(ip uint32) int {
+func (cc *perIPConnCounter) Register(ip netip.Prefix) int {
 	cc.lock.Lock()
 	if cc.m == nil {
-		cc.m = make(map[uint32]int)
+		cc.m = make(map[netip.Prefix]int)
 	}
 	n := cc.m[ip] + 1 // n用于返回
 	cc.m[ip] = n
@@ -24,7 +26,7 @@ func (cc *perIPConnCounter) Register(ip uint32) int {
 	return n
 }
 
-func (cc *perIPConnCounter) Unregister(ip uint32) {
+func (cc *perIPConnCounter) Unregister(ip netip.Prefix) {
 	cc.lock.Lock()
 	if cc.m == nil {
 		cc.lock.Unlock()
@@ -33,69 +35,121 @@ func (cc *perIPConnCounter) Unregister(ip uint32) {
 	n := cc.m[ip] - 1
 	if n < 0 {
 		cc.lock.Unlock()
-		panic(fmt.Sprintf("BUG: negative per-ip counter=%d for ip=%d", n, ip))
+		panic(fmt.Sprintf("BUG: negative per-ip counter=%d for ip=%s", n, ip))
+	}
+	if n == 0 {
+		delete(cc.m, ip) // 避免map随ip数量一直增长
+	} else {
+		cc.m[ip] = n
 	}
-	cc.m[ip] = n
 	cc.lock.Unlock()
 }
 
+// 返回各ip(前缀)的当前连接数
+// 按/32、/128聚合的，key为ip;否则为前缀,如"2001:db8::/64"
+func (cc *perIPConnCounter) Snapshot() map[string]int {
+	cc.lock.Lock()
+	m := make(map[string]int, len(cc.m))
+	for ip, n := range cc.m {
+		if ip.IsSingleIP() {
+			m[ip.Addr().String()] = n
+		} else {
+			m[ip.String()] = n
+		}
+	}
+	cc.lock.Unlock()
+	return m
+}
+
 // perIPConn
+// 不复用:ConnState等回调可能持有、关闭之,与服务协程并发
 type perIPConn struct {
 	net.Conn
 
-	ip               uint32
+	ip               netip.Prefix
 	perIPConnCounter *perIPConnCounter
+	closed           int32 // 1:已关闭,已从计数器中移除
 }
 
-func acquirePerIPConn(conn net.Conn, ip uint32, counter *perIPConnCounter) *perIPConn {
-	v := counter.pool.Get()
-	if v == nil {
-		v = &perIPConn{
-			perIPConnCounter: counter,
-		}
+func newPerIPConn(conn net.Conn, ip netip.Prefix, counter *perIPConnCounter) *perIPConn {
+	return &perIPConn{
+		Conn:             conn,
+		ip:               ip,
+		perIPConnCounter: counter,
 	}
-	c := v.(*perIPConn)
-	c.Conn = conn
-	c.ip = ip
-	return c
-}
-
-func releasePerIPConn(c *perIPConn) {
-	c.Conn = nil
-	c.perIPConnCounter.pool.Put(c)
 }
 
+// 可重复、并发调用,仅首次关闭连接并减少计数
 func (c *perIPConn) Close() error {
+	if !atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		return nil
+	}
 	err := c.Conn.Close()
 	c.perIPConnCounter.Unregister(c.ip)
-	releasePerIPConn(c)
 	return err
 }
 
-func getUint32IP(c net.Conn) uint32 {
-	return ip2uint32(getConnIP4(c))
-}
-func getConnIP4(c net.Conn) net.IP {
-	addr := c.RemoteAddr()
-	ipAddr, ok := addr.(*net.TCPAddr)
-	if !ok {
-		return net.IPv4zero
+// 默认聚合前缀:IPv4按单个ip,IPv6按/64(通常为一台主机/一个用户的网段)
+const (
+	defaultMaxConnsPerIPv4Prefix = 32
+	defaultMaxConnsPerIPv6Prefix = 64
+)
+
+// 取连接的ip前缀,作为计数key
+// 非ip连接(如unix socket)返回无效值
+func (s *Server) perIPConnKey(c net.Conn) netip.Prefix {
+	ip := getConnIP(c)
+	if !ip.IsValid() {
+		return netip.Prefix{}
+	}
+	bits := s.MaxConnsPerIPv4Prefix
+	if bits <= 0 || bits > 32 {
+		bits = defaultMaxConnsPerIPv4Prefix
+	}
+	if ip.Is6() {
+		bits = s.MaxConnsPerIPv6Prefix
+		if bits <= 0 || bits > 128 {
+			bits = defaultMaxConnsPerIPv6Prefix
+		}
+	}
+	p, err := ip.Prefix(bits)
+	if err != nil {
+		return netip.Prefix{}
 	}
-	return ipAddr.IP.To4()
+	return p
 }
 
-func ip2uint32(ip net.IP) uint32 {
-	if len(ip) != 4 {
-		return 0
+// 检测ip是否在MaxConnsPerIPExempt中
+func (s *Server) isPerIPExempt(ip netip.Addr) bool {
+	for _, p := range s.MaxConnsPerIPExempt {
+		if p.Contains(ip) {
+			return true
+		}
 	}
-	return uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3])
+	return false
+}
+
+// 取连接的远程ip
+// IPv4-mapped IPv6地址(::ffff:1.2.3.4)，转为IPv4
+func getConnIP(c net.Conn) netip.Addr {
+	return addrToNetIP(c.RemoteAddr())
 }
 
-func uint322ip(ip uint32) net.IP {
-	b := make([]byte, 4)
-	b[0] = byte(ip >> 24)
-	b[1] = byte(ip >> 16)
-	b[2] = byte(ip >> 8)
-	b[3] = byte(ip)
-	return b
+func addrToNetIP(addr net.Addr) netip.Addr {
+	var ip net.IP
+	switch x := addr.(type) {
+	case *net.TCPAddr:
+		ip = x.IP
+	case *net.UDPAddr:
+		ip = x.IP
+	case *net.IPAddr:
+		ip = x.IP
+	default:
+		return netip.Addr{}
+	}
+	a, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return netip.Addr{}
+	}
+	return a.Unmap()
 }
@@ -0,0 +1,137 @@
+package selfFastHttp
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/netip"
+	"testing"
+)
+
+// 仅提供RemoteAddr、Write、Close的连接
+type perIPTestConn struct {
+	net.Conn
+	addr   net.Addr
+	w      bytes.Buffer
+	closed bool
+}
+
+func newPerIPTestConn(addr string) *perIPTestConn {
+	return &perIPTestConn{addr: net.TCPAddrFromAddrPort(netip.MustParseAddrPort(addr))}
+}
+
+func (c *perIPTestConn) RemoteAddr() net.Addr        { return c.addr }
+func (c *perIPTestConn) Write(p []byte) (int, error) { return c.w.Write(p) }
+func (c *perIPTestConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestServerPerIPConnKey(t *testing.T) {
+	for _, tc := range []struct {
+		v4, v6 int
+		addr   string
+		want   string
+	}{
+		{0, 0, "1.2.3.4:80", "1.2.3.4/32"},
+		{24, 0, "1.2.3.4:80", "1.2.3.0/24"},
+		{33, 0, "1.2.3.4:80", "1.2.3.4/32"}, // 无效值用默认
+		{0, 0, "[2001:db8:1:2:3::4]:80", "2001:db8:1:2::/64"},
+		{0, 48, "[2001:db8:1:2:3::4]:80", "2001:db8:1::/48"},
+		{0, 128, "[2001:db8:1:2:3::4]:80", "2001:db8:1:2:3::4/128"},
+		{24, 0, "[::ffff:1.2.3.4]:80", "1.2.3.0/24"}, // IPv4-mapped按IPv4
+	} {
+		s := &Server{MaxConnsPerIPv4Prefix: tc.v4, MaxConnsPerIPv6Prefix: tc.v6}
+		if got := s.perIPConnKey(newPerIPTestConn(tc.addr)); got.String() != tc.want {
+			t.Errorf("v4=%d v6=%d %s: unexpected key %s, want %s", tc.v4, tc.v6, tc.addr, got, tc.want)
+		}
+	}
+
+	s := &Server{}
+	pc := &perIPTestConn{addr: &net.UnixAddr{Name: "/tmp/x.sock", Net: "unix"}}
+	if key := s.perIPConnKey(pc); key.IsValid() {
+		t.Errorf("unexpected key %s for unix conn", key)
+	}
+}
+
+func TestServerWrapPerIPConn(t *testing.T) {
+	s := &Server{
+		MaxConnsPerIP:       1,
+		MaxConnsPerIPExempt: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+	}
+
+	first := wrapPerIPConn(s, newPerIPTestConn("[2001:db8::1]:1000"))
+	if first == nil {
+		t.Fatal("first conn rejected")
+	}
+	// 同一/64网段的其它地址，合并计数
+	rejected := newPerIPTestConn("[2001:db8::2]:1000")
+	if wrapPerIPConn(s, rejected) != nil {
+		t.Fatal("conn from the same /64 accepted")
+	}
+	if !rejected.closed {
+		t.Fatal("rejected conn not closed")
+	}
+	var resp Response
+	if err := resp.Read(bufio.NewReader(&rejected.w)); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode() != StatusTooManyRequests {
+		t.Fatalf("unexpected status code %d, want %d", resp.StatusCode(), StatusTooManyRequests)
+	}
+	if n := s.Stats().RejectedPerIP; n != 1 {
+		t.Fatalf("unexpected RejectedPerIP %d, want 1", n)
+	}
+
+	if wrapPerIPConn(s, newPerIPTestConn("[2001:db8:0:1::1]:1000")) == nil {
+		t.Fatal("conn from another /64 rejected")
+	}
+	for i := 0; i < 3; i++ {
+		c := newPerIPTestConn("10.1.2.3:1000")
+		if wrapPerIPConn(s, c) != c {
+			t.Fatal("exempt conn wrapped or rejected")
+		}
+	}
+	if m := s.perIPConnCounter.Snapshot(); len(m) != 2 || m["2001:db8::/64"] != 1 {
+		t.Fatalf("unexpected counters %v", m)
+	}
+
+	// 关闭后释放计数,重复关闭不重复释放
+	first.Close()
+	first.Close()
+	if wrapPerIPConn(s, newPerIPTestConn("[2001:db8::3]:1000")) == nil {
+		t.Fatal("conn rejected after the previous one closed")
+	}
+	if m := s.perIPConnCounter.Snapshot(); m["2001:db8::/64"] != 1 {
+		t.Fatalf("unexpected counters %v", m)
+	}
+}
+
+// 超出MaxConnsPerIP的连接收到StatusTooManyRequests
+func TestServerMaxConnsPerIP(t *testing.T) {
+	s := &Server{
+		Handler:       func(ctx *RequestCtx) { ctx.WriteString("ok") },
+		MaxConnsPerIP: 1,
+		Logger:        &testLogger{},
+	}
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go s.Serve(ln)
+
+	c1, err := net.Dial("tcp4", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c1.Close()
+	testDoRaw(t, c1, bufio.NewReader(c1), testGetRequest)
+
+	c2, err := net.Dial("tcp4", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+	expectStatusCode(t, c2, StatusTooManyRequests)
+}
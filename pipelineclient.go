@@ -0,0 +1,659 @@
+package selfFastHttp
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 每个连接的默认最大等待请求数
+const DefaultMaxPendingRequests = 1024
+
+var (
+	// 等待发送的请求数已达MaxPendingRequests
+	ErrPipelineOverflow = errors.New("pipelined requests' queue has been overflown. Increase MaxConns and/or MaxPendingRequests")
+
+	errPipelineConnStopped = errors.New("pipeline connection has been stopped")
+)
+
+// HTTP/1.1 pipelining客户端
+// 在同一连接上连续发送多个请求，不等待响应,按发送顺序读取响应
+// 适用于对单个host的大量并发请求,减少往返等待
+// 服务端须支持pipelining(如本包的Server)
+// 须用零值或指定参数创建，不可复制
+// 可在多个协程中并发使用
+type PipelineClient struct {
+	noCopy noCopy
+
+	// host地址,须含端口,如"foobar.com:80"
+	Addr string
+
+	// 最大连接数
+	// 新请求发往等待请求最少的连接,所有连接均有等待请求且未达MaxConns时，新建连接
+	// 默认1
+	MaxConns int
+
+	// 每个连接的最大等待请求数(待发送+待响应)
+	// 超出时Do返回ErrPipelineOverflow
+	// 默认DefaultMaxPendingRequests
+	MaxPendingRequests int
+
+	// 合并发送的最长等待时间:请求写入缓冲后,等待该时间再flush,使多个请求合并为一次写入
+	// 默认0,无等待请求时立即flush
+	MaxBatchDelay time.Duration
+
+	// 建立到Addr的连接
+	// 默认Dial(TCP4),见DialDualStack
+	Dial DialFunc
+
+	// 为true时,默认的Dial支持IPv6
+	DialDualStack bool
+
+	// 是否使用tls连接
+	IsTLS bool
+
+	// tls连接的配置
+	// 默认的配置校验服务端证书,ServerName未设置时使用Addr中的host
+	TLSConfig *tls.Config
+
+	// 连接无请求超过该时间后关闭,有新请求时再建立
+	// 默认DefaultMaxIdleConnDuration
+	MaxIdleConnDuration time.Duration
+
+	// 每个连接的读缓冲大小,同时限制响应头大小
+	// 默认defaultReadBufferSize
+	ReadBufferSize int
+
+	// 每个连接的写缓冲大小
+	// 默认defaultWriteBufferSize
+	WriteBufferSize int
+
+	// 读取每个响应的最长时间
+	// 默认无限制
+	ReadTimeout time.Duration
+
+	// 写入每个请求的最长时间
+	// 默认无限制
+	WriteTimeout time.Duration
+
+	// 连接出错时的日志
+	// 默认defaultLogger
+	Logger Logger
+
+	connClients     []*pipelineConnClient
+	connClientsLock sync.Mutex
+}
+
+// 单个连接的pipelining客户端
+// 连接由worker协程建立,写协程按序发送chW中的请求并放入chR,读协程按序读取chR中请求的响应
+type pipelineConnClient struct {
+	noCopy noCopy
+
+	Addr                string
+	MaxPendingRequests  int
+	MaxBatchDelay       time.Duration
+	Dial                DialFunc
+	DialDualStack       bool
+	IsTLS               bool
+	TLSConfig           *tls.Config
+	MaxIdleConnDuration time.Duration
+	ReadBufferSize      int
+	WriteBufferSize     int
+	ReadTimeout         time.Duration
+	WriteTimeout        time.Duration
+	Logger              Logger
+
+	workPool sync.Pool
+
+	chLock sync.Mutex
+	chW    chan *pipelineWork // 待发送
+	chR    chan *pipelineWork // 已发送，待响应
+	resend []*pipelineWork    // 已发送,但服务端关闭连接前未响应,在新连接上先于chW重发
+
+	inflight int32 // 已发送、未读完响应的请求数(含读协程正在读取的)
+
+	tlsConfigLock   sync.Mutex
+	cachedTLSConfig *tls.Config
+}
+
+type pipelineWork struct {
+	reqCopy  Request
+	respCopy Response
+	req      *Request
+	resp     *Response
+	t        *time.Timer
+	deadline time.Time // 零值时无超时
+	err      error
+	done     chan struct{}
+}
+
+// 发送req,获取resp
+// req须设置Host头或包含完整url
+// 等待请求数超过MaxPendingRequests时，返回ErrPipelineOverflow
+func (c *PipelineClient) Do(req *Request, resp *Response) error {
+	return c.getConnClient().Do(req, resp)
+}
+
+// 同Do,在timeout内未获得响应时，返回ErrTimeout
+func (c *PipelineClient) DoTimeout(req *Request, resp *Response, timeout time.Duration) error {
+	return c.DoDeadline(req, resp, time.Now().Add(timeout))
+}
+
+// 同Do,在deadline前未获得响应时，返回ErrTimeout
+// 超时后,已发送的请求仍在连接上等待响应,不影响req、resp的复用
+func (c *PipelineClient) DoDeadline(req *Request, resp *Response, deadline time.Time) error {
+	return c.getConnClient().DoDeadline(req, resp, deadline)
+}
+
+// 返回所有连接的等待请求数
+func (c *PipelineClient) PendingRequests() int {
+	c.connClientsLock.Lock()
+	n := 0
+	for _, cc := range c.connClients {
+		n += cc.PendingRequests()
+	}
+	c.connClientsLock.Unlock()
+	return n
+}
+
+func (c *PipelineClient) getConnClient() *pipelineConnClient {
+	c.connClientsLock.Lock()
+	cc := c.getConnClientUnlocked()
+	c.connClientsLock.Unlock()
+	return cc
+}
+
+// 返回等待请求最少的连接
+func (c *PipelineClient) getConnClientUnlocked() *pipelineConnClient {
+	if len(c.connClients) == 0 {
+		return c.newConnClient()
+	}
+
+	minCC := c.connClients[0]
+	minReqs := minCC.PendingRequests()
+	if minReqs == 0 {
+		return minCC
+	}
+	for i := 1; i < len(c.connClients); i++ {
+		cc := c.connClients[i]
+		reqs := cc.PendingRequests()
+		if reqs == 0 {
+			return cc
+		}
+		if reqs < minReqs {
+			minCC = cc
+			minReqs = reqs
+		}
+	}
+
+	maxConns := c.MaxConns
+	if maxConns <= 0 {
+		maxConns = 1
+	}
+	if len(c.connClients) < maxConns {
+		return c.newConnClient()
+	}
+	return minCC
+}
+
+func (c *PipelineClient) newConnClient() *pipelineConnClient {
+	cc := &pipelineConnClient{
+		Addr:                c.Addr,
+		MaxPendingRequests:  c.MaxPendingRequests,
+		MaxBatchDelay:       c.MaxBatchDelay,
+		Dial:                c.Dial,
+		DialDualStack:       c.DialDualStack,
+		IsTLS:               c.IsTLS,
+		TLSConfig:           c.TLSConfig,
+		MaxIdleConnDuration: c.MaxIdleConnDuration,
+		ReadBufferSize:      c.ReadBufferSize,
+		WriteBufferSize:     c.WriteBufferSize,
+		ReadTimeout:         c.ReadTimeout,
+		WriteTimeout:        c.WriteTimeout,
+		Logger:              c.Logger,
+	}
+	c.connClients = append(c.connClients, cc)
+	return cc
+}
+
+// 发送req,等待响应
+func (c *pipelineConnClient) Do(req *Request, resp *Response) error {
+	w := acquirePipelineWork(&c.workPool, 0)
+	w.req = req
+	w.resp = resp
+	if err := c.push(w); err != nil {
+		releasePipelineWork(&c.workPool, w)
+		return err
+	}
+
+	<-w.done
+	err := w.err
+	releasePipelineWork(&c.workPool, w)
+	return err
+}
+
+// 发送req的副本,超时后放弃等待
+// 超时的work由读写协程继续处理,不还回池中
+func (c *pipelineConnClient) DoDeadline(req *Request, resp *Response, deadline time.Time) error {
+	timeout := time.Until(deadline)
+	if timeout <= 0 {
+		return ErrTimeout
+	}
+
+	w := acquirePipelineWork(&c.workPool, timeout)
+	req.CopyTo(&w.reqCopy)
+	w.req = &w.reqCopy
+	w.resp = &w.respCopy
+	if err := c.push(w); err != nil {
+		releasePipelineWork(&c.workPool, w)
+		return err
+	}
+
+	select {
+	case <-w.done:
+		err := w.err
+		if err == nil {
+			w.respCopy.CopyTo(resp)
+		}
+		releasePipelineWork(&c.workPool, w)
+		return err
+	case <-w.t.C:
+		return ErrTimeout
+	}
+}
+
+// 将w放入待发送队列
+// 与worker协程的退出检查同在chLock下，以免请求滞留在已无worker的队列中
+func (c *pipelineConnClient) push(w *pipelineWork) error {
+	c.chLock.Lock()
+	defer c.chLock.Unlock()
+	c.initLocked()
+	if c.pendingRequestsLocked() >= cap(c.chW) {
+		return ErrPipelineOverflow
+	}
+	select {
+	case c.chW <- w:
+		return nil
+	default:
+		return ErrPipelineOverflow
+	}
+}
+
+// 返回等待请求数(待发送+待响应)
+func (c *pipelineConnClient) PendingRequests() int {
+	c.chLock.Lock()
+	n := c.pendingRequestsLocked()
+	c.chLock.Unlock()
+	return n
+}
+
+func (c *pipelineConnClient) pendingRequestsLocked() int {
+	return len(c.chR) + len(c.chW) + len(c.resend)
+}
+
+// 启动worker协程,须持有chLock
+// 协程在无等待请求、连接空闲超时后退出，有新请求时再启动
+func (c *pipelineConnClient) initLocked() {
+	if c.chR == nil {
+		maxPendingRequests := c.MaxPendingRequests
+		if maxPendingRequests <= 0 {
+			maxPendingRequests = DefaultMaxPendingRequests
+		}
+		c.chR = make(chan *pipelineWork, maxPendingRequests)
+		if c.chW == nil {
+			c.chW = make(chan *pipelineWork, maxPendingRequests)
+		}
+		go func() {
+			// 连接出错时，重新建立
+			for {
+				if err := c.worker(); err != nil {
+					c.logger().Printf("error in PipelineClient(%q): %s", c.Addr, err)
+					if ne, ok := err.(net.Error); ok && ne.Timeout() {
+						time.Sleep(time.Second) // 减缓重连
+					}
+				}
+
+				c.chLock.Lock()
+				stop := c.pendingRequestsLocked() == 0
+				if !stop {
+					c.chLock.Unlock()
+					continue
+				}
+				c.chR = nil
+				c.chLock.Unlock()
+				return
+			}
+		}()
+	}
+}
+
+// 建立连接，运行读写协程,直到其中之一退出
+// 返回nil:连接空闲超时或服务端要求关闭连接
+func (c *pipelineConnClient) worker() error {
+	conn, err := dialAddr(c.Addr, c.Dial, c.DialDualStack)
+	if err != nil {
+		// 连接无法建立,待发送的请求均返回错误
+		c.failResend(err)
+		c.failPending(c.chW, err)
+		return err
+	}
+	if c.IsTLS {
+		conn = tls.Client(conn, c.tlsConfig())
+	}
+	atomic.StoreInt32(&c.inflight, 0) // 上一连接未完成的请求已由failPending结束
+
+	var unsentW *pipelineWork
+	stopW := make(chan struct{})
+	doneW := make(chan error)
+	go func() {
+		var err error
+		unsentW, err = c.writer(conn, stopW)
+		doneW <- err
+	}()
+	stopR := make(chan struct{})
+	doneR := make(chan error)
+	go func() {
+		doneR <- c.reader(conn, stopR)
+	}()
+
+	closedByServer := false
+	select {
+	case err = <-doneW:
+		conn.Close()
+		close(stopR)
+		<-doneR
+	case err = <-doneR:
+		// 读协程返回nil:服务端响应'Connection: close'
+		closedByServer = err == nil
+		conn.Close()
+		close(stopW)
+		<-doneW
+	}
+
+	if closedByServer {
+		// 服务端不会处理之后的请求,在新连接上按原顺序重发
+		c.chLock.Lock()
+		for len(c.chR) > 0 {
+			c.resend = append(c.resend, <-c.chR)
+		}
+		if unsentW != nil {
+			c.resend = append(c.resend, unsentW)
+		}
+		c.chLock.Unlock()
+		return err
+	}
+
+	// 已发送的请求无法再获得响应
+	if unsentW != nil {
+		unsentW.err = errPipelineConnStopped
+		unsentW.done <- struct{}{}
+	}
+	c.failResend(errPipelineConnStopped)
+	c.failPending(c.chR, errPipelineConnStopped)
+	return err
+}
+
+// 以err结束待重发的请求
+func (c *pipelineConnClient) failResend(err error) {
+	c.chLock.Lock()
+	resend := c.resend
+	c.resend = nil
+	c.chLock.Unlock()
+	for _, w := range resend {
+		w.err = err
+		w.done <- struct{}{}
+	}
+}
+
+// 取出下一个待重发的请求
+func (c *pipelineConnClient) popResend() *pipelineWork {
+	c.chLock.Lock()
+	defer c.chLock.Unlock()
+	if len(c.resend) == 0 {
+		return nil
+	}
+	w := c.resend[0]
+	c.resend[0] = nil
+	c.resend = c.resend[1:]
+	return w
+}
+
+// 以err结束ch中所有等待的请求
+func (c *pipelineConnClient) failPending(ch chan *pipelineWork, err error) {
+	for {
+		select {
+		case w := <-ch:
+			w.err = err
+			w.done <- struct{}{}
+		default:
+			return
+		}
+	}
+}
+
+// 写协程:先发送待重发的请求,再按序发送chW中的请求,放入chR
+// 无待发送请求时flush;MaxBatchDelay>0时，延迟flush以合并写入
+// 无待发送、待响应的请求达MaxIdleConnDuration时，退出以关闭连接
+// 停止时返回已发送但未能放入chR的请求,由worker处理
+func (c *pipelineConnClient) writer(conn net.Conn, stopCh <-chan struct{}) (*pipelineWork, error) {
+	writeBufferSize := c.WriteBufferSize
+	if writeBufferSize <= 0 {
+		writeBufferSize = defaultWriteBufferSize
+	}
+	bw := bufio.NewWriterSize(conn, writeBufferSize)
+	defer bw.Flush()
+	chR := c.chR
+	chW := c.chW
+	writeTimeout := c.WriteTimeout
+
+	maxIdleConnDuration := c.MaxIdleConnDuration
+	if maxIdleConnDuration <= 0 {
+		maxIdleConnDuration = DefaultMaxIdleConnDuration
+	}
+	maxBatchDelay := c.MaxBatchDelay
+
+	var (
+		stopTimer      = time.NewTimer(time.Hour)
+		flushTimer     = time.NewTimer(time.Hour)
+		flushTimerCh   <-chan time.Time
+		instantTimerCh = make(chan time.Time)
+
+		w   *pipelineWork
+		err error
+	)
+	defer stopTimer.Stop()
+	defer flushTimer.Stop()
+	close(instantTimerCh) // 立即可读,用于无延迟flush
+
+	for {
+		if w = c.popResend(); w != nil {
+			goto writeW
+		}
+	againChW:
+		select {
+		case w = <-chW:
+		default:
+			// 无待发送请求:等待新请求、flush或空闲超时
+			resetTimer(stopTimer, maxIdleConnDuration)
+			select {
+			case w = <-chW:
+			case <-stopTimer.C:
+				// 仍有请求待响应时，连接并非空闲
+				if len(chR) == 0 && atomic.LoadInt32(&c.inflight) == 0 {
+					return nil, nil
+				}
+				goto againChW
+			case <-stopCh:
+				return nil, nil
+			case <-flushTimerCh:
+				if err = bw.Flush(); err != nil {
+					return nil, err
+				}
+				flushTimerCh = nil
+				goto againChW
+			}
+		}
+
+	writeW:
+		if !w.deadline.IsZero() && !time.Now().Before(w.deadline) {
+			w.err = ErrTimeout
+			w.done <- struct{}{}
+			continue
+		}
+
+		if writeTimeout > 0 {
+			if err = conn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+				w.err = err
+				w.done <- struct{}{}
+				return nil, err
+			}
+		}
+		if err = w.req.Write(bw); err != nil {
+			w.err = err
+			w.done <- struct{}{}
+			return nil, err
+		}
+		if flushTimerCh == nil && (len(chW) == 0 || len(chR) == cap(chR)) {
+			if maxBatchDelay > 0 {
+				resetTimer(flushTimer, maxBatchDelay)
+				flushTimerCh = flushTimer.C
+			} else {
+				flushTimerCh = instantTimerCh
+			}
+		}
+
+		atomic.AddInt32(&c.inflight, 1)
+	againChR:
+		select {
+		case chR <- w:
+		default:
+			// 待响应队列已满:flush,等待读协程取走
+			select {
+			case chR <- w:
+			case <-stopCh:
+				return w, nil
+			case <-flushTimerCh:
+				if err = bw.Flush(); err != nil {
+					w.err = err
+					w.done <- struct{}{}
+					return nil, err
+				}
+				flushTimerCh = nil
+				goto againChR
+			}
+		}
+	}
+}
+
+// 读协程:按序读取chR中请求的响应
+// 响应为'Connection: close'时退出,worker重新建立连接
+func (c *pipelineConnClient) reader(conn net.Conn, stopCh <-chan struct{}) error {
+	readBufferSize := c.ReadBufferSize
+	if readBufferSize <= 0 {
+		readBufferSize = defaultReadBufferSize
+	}
+	br := bufio.NewReaderSize(conn, readBufferSize)
+	chR := c.chR
+	readTimeout := c.ReadTimeout
+
+	var (
+		w   *pipelineWork
+		err error
+	)
+	for {
+		select {
+		case w = <-chR:
+		case <-stopCh:
+			return nil
+		}
+
+		if readTimeout > 0 {
+			if err = conn.SetReadDeadline(time.Now().Add(readTimeout)); err != nil {
+				w.err = err
+				w.done <- struct{}{}
+				return err
+			}
+		}
+		// HEAD请求的响应无body
+		skipBody := w.resp.SkipBody
+		w.resp.SkipBody = skipBody || w.req.Header.IsHead()
+		err = w.resp.Read(br)
+		w.resp.SkipBody = skipBody
+		atomic.AddInt32(&c.inflight, -1)
+		if err != nil {
+			w.err = err
+			w.done <- struct{}{}
+			return err
+		}
+		closeConn := w.resp.ConnectionClose()
+		w.done <- struct{}{}
+		if closeConn {
+			return nil
+		}
+	}
+}
+
+func (c *pipelineConnClient) logger() Logger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+	return defaultLogger
+}
+
+func (c *pipelineConnClient) tlsConfig() *tls.Config {
+	c.tlsConfigLock.Lock()
+	defer c.tlsConfigLock.Unlock()
+	if c.cachedTLSConfig == nil {
+		c.cachedTLSConfig = newClientTLSConfig(c.TLSConfig, c.Addr)
+	}
+	return c.cachedTLSConfig
+}
+
+// 停止t并清空t.C,再重新计时
+func resetTimer(t *time.Timer, d time.Duration) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+	t.Reset(d)
+}
+
+// timeout>0时，附带超时定时器
+func acquirePipelineWork(pool *sync.Pool, timeout time.Duration) *pipelineWork {
+	v := pool.Get()
+	if v == nil {
+		v = &pipelineWork{
+			done: make(chan struct{}, 1),
+		}
+	}
+	w := v.(*pipelineWork)
+	if timeout > 0 {
+		if w.t == nil {
+			w.t = time.NewTimer(timeout)
+		} else {
+			resetTimer(w.t, timeout)
+		}
+		w.deadline = time.Now().Add(timeout)
+	} else {
+		w.deadline = zeroTime
+	}
+	return w
+}
+
+func releasePipelineWork(pool *sync.Pool, w *pipelineWork) {
+	if w.t != nil {
+		w.t.Stop()
+	}
+	w.reqCopy.Reset()
+	w.respCopy.Reset()
+	w.req = nil
+	w.resp = nil
+	w.err = nil
+	pool.Put(w)
+}
@@ -0,0 +1,115 @@
+package selfFastHttp
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 启动Handler阻塞在release上的服务,started在Handler首次被调用时关闭
+func startBlockingPipelineServer(t *testing.T, block string) (c *PipelineClient, dials *int32, started, release chan struct{}) {
+	started = make(chan struct{})
+	release = make(chan struct{})
+	var once int32
+	s := &Server{Handler: func(ctx *RequestCtx) {
+		if string(ctx.Path()) == block {
+			if atomic.CompareAndSwapInt32(&once, 0, 1) {
+				close(started)
+			}
+			<-release
+			ctx.SetConnectionClose()
+		}
+		ctx.Write(ctx.Path())
+	}}
+	ln, _ := startInmemoryServer(t, s)
+	dials = new(int32)
+	c = &PipelineClient{Addr: "example.com:80", Dial: inmemoryDial(ln, dials)}
+	return c, dials, started, release
+}
+
+// 等待c的等待请求数达到n
+func waitPendingRequests(t *testing.T, c *PipelineClient, n int) {
+	for start := time.Now(); c.PendingRequests() != n; time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatalf("unexpected PendingRequests %d, want %d", c.PendingRequests(), n)
+		}
+	}
+}
+
+func TestPipelineClientDo(t *testing.T) {
+	s := &Server{Handler: func(ctx *RequestCtx) { ctx.Write(ctx.Path()) }}
+	ln, _ := startInmemoryServer(t, s)
+	var dials int32
+	c := &PipelineClient{Addr: "example.com:80", Dial: inmemoryDial(ln, &dials), MaxBatchDelay: time.Millisecond}
+
+	var errChs []<-chan error
+	for _, path := range []string{"/a", "/b", "/c", "/d", "/e"} {
+		errChs = append(errChs, goPipelineGet(c, path))
+	}
+	for _, errCh := range errChs {
+		if err := <-errCh; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(&dials); n != 1 {
+		t.Fatalf("unexpected dials %d, want 1", n)
+	}
+}
+
+// 已发送、待响应的请求同样计入MaxPendingRequests
+func TestPipelineClientMaxPendingRequests(t *testing.T) {
+	c, _, started, release := startBlockingPipelineServer(t, "/block")
+	c.MaxPendingRequests = 2
+	defer close(release)
+
+	goPipelineGet(c, "/block")
+	<-started
+	goPipelineGet(c, "/a")
+	goPipelineGet(c, "/a")
+	waitPendingRequests(t, c, 2)
+
+	if err := c.DoTimeout(testPipelineRequest("/b"), &Response{}, time.Second); err != ErrPipelineOverflow {
+		t.Fatalf("unexpected error %v, want %v", err, ErrPipelineOverflow)
+	}
+}
+
+// 服务端响应'Connection: close'后,已发送的请求在新连接上重发
+func TestPipelineClientConnectionCloseResend(t *testing.T) {
+	c, dials, started, release := startBlockingPipelineServer(t, "/close")
+
+	errChs := []<-chan error{goPipelineGet(c, "/close")}
+	<-started
+	errChs = append(errChs, goPipelineGet(c, "/a"), goPipelineGet(c, "/b"))
+	waitPendingRequests(t, c, 2)
+	close(release)
+
+	for _, errCh := range errChs {
+		if err := <-errCh; err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	if n := atomic.LoadInt32(dials); n != 2 {
+		t.Fatalf("unexpected dials %d, want 2", n)
+	}
+}
+
+func testPipelineRequest(path string) *Request {
+	req := &Request{}
+	req.SetRequestURI("http://example.com" + path)
+	return req
+}
+
+// 在新协程中请求path,校验响应body为path
+func goPipelineGet(c *PipelineClient, path string) <-chan error {
+	errCh := make(chan error, 1)
+	go func() {
+		var resp Response
+		err := c.DoTimeout(testPipelineRequest(path), &resp, time.Second)
+		if err == nil && string(resp.Body()) != path {
+			err = fmt.Errorf("unexpected body %q, want %q", resp.Body(), path)
+		}
+		errCh <- err
+	}()
+	return errCh
+}
@@ -0,0 +1,320 @@
+package selfFastHttp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// PROXY protocol的处理方式,见Server.ProxyProtocol
+// https://www.haproxy.org/download/2.8/doc/proxy-protocol.txt
+type ProxyProtocolMode int
+
+const (
+	// 不解析PROXY protocol头
+	ProxyProtocolOff ProxyProtocolMode = iota
+
+	// 有PROXY protocol头时解析之，无则按普通连接处理
+	ProxyProtocolOptional
+
+	// 须有PROXY protocol头，否则关闭连接
+	ProxyProtocolRequired
+)
+
+// 读取PROXY protocol头的默认超时时间
+const DefaultProxyProtocolHeaderTimeout = 5 * time.Second
+
+var (
+	errProxyProtocolMissing = errors.New("missing PROXY protocol header")
+	errProxyProtocolInvalid = errors.New("invalid PROXY protocol header")
+)
+
+// PROXY protocol v2头的签名
+var proxyProtocolV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// v1头的最大长度,含CRLF
+const proxyProtocolV1MaxLen = 107
+
+// 解析PROXY protocol v1/v2头的监听器
+// 连接的RemoteAddr、LocalAddr为头中的原始客户端地址，供RequestCtx.RemoteAddr、MaxConnsPerIP等使用
+// 每个连接在单独的协程中读取头，慢连接不阻塞Accept
+// 头无效或超时，关闭连接
+type ProxyProtocolListener struct {
+	// 被封装的监听器
+	Listener net.Listener
+
+	// 为true时，无头的连接按普通连接处理;否则关闭之
+	Optional bool
+
+	// 可信的来源(负载均衡器)地址,仅解析来自这些地址的连接的头
+	// 其它连接按普通连接处理
+	// 默认信任所有来源
+	Trusted []netip.Prefix
+
+	// 读取头的超时时间
+	// 默认DefaultProxyProtocolHeaderTimeout
+	HeaderTimeout time.Duration
+
+	acceptor  asyncAcceptor
+	startOnce sync.Once
+}
+
+func (ln *ProxyProtocolListener) init() {
+	ln.acceptor.ln = ln.Listener
+	ln.acceptor.prepare = ln.readHeader
+	ln.acceptor.skip = func(c net.Conn) bool {
+		return !ln.isTrusted(c)
+	}
+}
+
+// 返回已读取头的连接
+func (ln *ProxyProtocolListener) Accept() (net.Conn, error) {
+	ln.startOnce.Do(ln.init)
+	return ln.acceptor.Accept()
+}
+
+func (ln *ProxyProtocolListener) Close() error {
+	ln.startOnce.Do(ln.init)
+	return ln.acceptor.Close()
+}
+
+func (ln *ProxyProtocolListener) Addr() net.Addr {
+	return ln.Listener.Addr()
+}
+
+func (ln *ProxyProtocolListener) isTrusted(c net.Conn) bool {
+	if len(ln.Trusted) == 0 {
+		return true
+	}
+	ip := getConnIP(c)
+	for _, p := range ln.Trusted {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ln *ProxyProtocolListener) readHeader(c net.Conn) (net.Conn, error) {
+	timeout := ln.HeaderTimeout
+	if timeout <= 0 {
+		timeout = DefaultProxyProtocolHeaderTimeout
+	}
+	c.SetReadDeadline(time.Now().Add(timeout))
+	pc, err := readProxyProtocolHeader(c, ln.Optional)
+	if err != nil {
+		return nil, err
+	}
+	c.SetReadDeadline(zeroTime)
+	return pc, nil
+}
+
+// 按Server.ProxyProtocol封装ln
+func (s *Server) newProxyProtocolListener(ln net.Listener) net.Listener {
+	if s.ProxyProtocol == ProxyProtocolOff {
+		return ln
+	}
+	if _, ok := ln.(*ProxyProtocolListener); ok {
+		return ln
+	}
+	return &ProxyProtocolListener{
+		Listener:      ln,
+		Optional:      s.ProxyProtocol == ProxyProtocolOptional,
+		Trusted:       s.ProxyProtocolTrusted,
+		HeaderTimeout: s.ProxyProtocolHeaderTimeout,
+	}
+}
+
+// 已读取PROXY protocol头的连接
+type proxyProtocolConn struct {
+	net.Conn
+
+	rest       []byte // 读取头时，多读的数据
+	remoteAddr net.Addr
+	localAddr  net.Addr
+}
+
+func (c *proxyProtocolConn) Read(p []byte) (int, error) {
+	if len(c.rest) > 0 {
+		n := copy(p, c.rest)
+		c.rest = c.rest[n:]
+		return n, nil
+	}
+	return c.Conn.Read(p)
+}
+
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+func (c *proxyProtocolConn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+var proxyProtocolReaderPool sync.Pool
+
+// 读取c的PROXY protocol头，返回报告原始地址的连接
+// optional为true时，无头的连接原样返回(已读数据不丢失)
+func readProxyProtocolHeader(c net.Conn, optional bool) (net.Conn, error) {
+	var br *bufio.Reader
+	if v := proxyProtocolReaderPool.Get(); v != nil {
+		br = v.(*bufio.Reader)
+		br.Reset(c)
+	} else {
+		br = bufio.NewReaderSize(c, 256)
+	}
+	pc := &proxyProtocolConn{
+		Conn:       c,
+		remoteAddr: c.RemoteAddr(),
+		localAddr:  c.LocalAddr(),
+	}
+	err := parseProxyProtocolHeader(br, pc, optional)
+	if err == nil && br.Buffered() > 0 {
+		b, _ := br.Peek(br.Buffered())
+		pc.rest = append(pc.rest[:0], b...)
+	}
+	br.Reset(nil)
+	proxyProtocolReaderPool.Put(br)
+	if err != nil {
+		return nil, err
+	}
+	return pc, nil
+}
+
+func parseProxyProtocolHeader(br *bufio.Reader, pc *proxyProtocolConn, optional bool) error {
+	b, err := br.Peek(1)
+	if err != nil {
+		return err
+	}
+	switch b[0] {
+	case 'P':
+		if b, err = br.Peek(6); err == nil && string(b) == "PROXY " {
+			return parseProxyProtocolV1(br, pc)
+		}
+	case '\r':
+		if b, err = br.Peek(len(proxyProtocolV2Sig)); err == nil && bytes.Equal(b, proxyProtocolV2Sig) {
+			return parseProxyProtocolV2(br, pc)
+		}
+	}
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if optional {
+		return nil
+	}
+	return errProxyProtocolMissing
+}
+
+// v1:"PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n"
+func parseProxyProtocolV1(br *bufio.Reader, pc *proxyProtocolConn) error {
+	var line []byte
+	for {
+		b, err := br.ReadSlice('\n')
+		line = append(line, b...)
+		if len(line) > proxyProtocolV1MaxLen {
+			return errProxyProtocolInvalid
+		}
+		if err == nil {
+			break
+		}
+		if err != bufio.ErrBufferFull {
+			return err
+		}
+	}
+	if !bytes.HasSuffix(line, strCRLF) {
+		return errProxyProtocolInvalid
+	}
+	fields := bytes.Split(line[:len(line)-len(strCRLF)], []byte{' '})
+	if len(fields) < 2 {
+		return errProxyProtocolInvalid
+	}
+	switch string(fields[1]) {
+	case "UNKNOWN": // 使用原连接的地址
+		return nil
+	case "TCP4", "TCP6":
+	default:
+		return errProxyProtocolInvalid
+	}
+	if len(fields) != 6 {
+		return errProxyProtocolInvalid
+	}
+	src, err := parseProxyProtocolV1Addr(fields[2], fields[4])
+	if err != nil {
+		return err
+	}
+	dst, err := parseProxyProtocolV1Addr(fields[3], fields[5])
+	if err != nil {
+		return err
+	}
+	pc.remoteAddr = src
+	pc.localAddr = dst
+	return nil
+}
+
+func parseProxyProtocolV1Addr(ip, port []byte) (net.Addr, error) {
+	addr, err := netip.ParseAddr(string(ip))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", errProxyProtocolInvalid, err)
+	}
+	n, err := strconv.ParseUint(string(port), 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", errProxyProtocolInvalid, err)
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(n))), nil
+}
+
+// v2:12字节签名 + 1字节版本/命令 + 1字节协议族 + 2字节地址长度 + 地址(+TLV)
+func parseProxyProtocolV2(br *bufio.Reader, pc *proxyProtocolConn) error {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(br, hdr); err != nil {
+		return err
+	}
+	verCmd, fam := hdr[12], hdr[13]
+	if verCmd>>4 != 2 {
+		return errProxyProtocolInvalid
+	}
+	data := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err := io.ReadFull(br, data); err != nil {
+		return err
+	}
+	switch verCmd & 0xf {
+	case 0: // LOCAL:负载均衡器自身的连接(如健康检查)，使用原连接的地址
+		return nil
+	case 1: // PROXY
+	default:
+		return errProxyProtocolInvalid
+	}
+
+	var ipLen int
+	switch fam >> 4 {
+	case 1: // AF_INET
+		ipLen = net.IPv4len
+	case 2: // AF_INET6
+		ipLen = net.IPv6len
+	default: // AF_UNSPEC、AF_UNIX:使用原连接的地址
+		return nil
+	}
+	if len(data) < 2*ipLen+4 {
+		return errProxyProtocolInvalid
+	}
+	srcIP, _ := netip.AddrFromSlice(data[:ipLen])
+	dstIP, _ := netip.AddrFromSlice(data[ipLen : 2*ipLen])
+	srcPort := binary.BigEndian.Uint16(data[2*ipLen:])
+	dstPort := binary.BigEndian.Uint16(data[2*ipLen+2:])
+	if fam&0xf == 2 { // DGRAM
+		pc.remoteAddr = net.UDPAddrFromAddrPort(netip.AddrPortFrom(srcIP, srcPort))
+		pc.localAddr = net.UDPAddrFromAddrPort(netip.AddrPortFrom(dstIP, dstPort))
+	} else {
+		pc.remoteAddr = net.TCPAddrFromAddrPort(netip.AddrPortFrom(srcIP, srcPort))
+		pc.localAddr = net.TCPAddrFromAddrPort(netip.AddrPortFrom(dstIP, dstPort))
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package selfFastHttp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// 从内存读取数据的连接
+type proxyProtocolTestConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *proxyProtocolTestConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+func (c *proxyProtocolTestConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1000}
+}
+func (c *proxyProtocolTestConn) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 80}
+}
+func (c *proxyProtocolTestConn) SetReadDeadline(time.Time) error { return nil }
+
+// 生成v2头:verCmd、fam，及地址数据
+func proxyProtocolV2Header(verCmd, fam byte, data []byte) string {
+	hdr := append([]byte(nil), proxyProtocolV2Sig...)
+	hdr = append(hdr, verCmd, fam, 0, 0)
+	binary.BigEndian.PutUint16(hdr[14:], uint16(len(data)))
+	return string(append(hdr, data...))
+}
+
+func proxyProtocolV2Addrs(src, dst string, srcPort, dstPort uint16, tlv []byte) []byte {
+	s, d := netip.MustParseAddr(src), netip.MustParseAddr(dst)
+	data := append(s.AsSlice(), d.AsSlice()...)
+	data = binary.BigEndian.AppendUint16(data, srcPort)
+	data = binary.BigEndian.AppendUint16(data, dstPort)
+	return append(data, tlv...)
+}
+
+func TestReadProxyProtocolHeader(t *testing.T) {
+	const body = "GET / HTTP/1.1\r\nHost: a\r\n\r\n"
+	const origRemote, origLocal = "10.0.0.1:1000", "10.0.0.2:80"
+
+	for _, tc := range []struct {
+		name     string
+		header   string
+		optional bool   // 为true的用例均无头
+		remote   string // 为空时，期望出错
+		local    string
+	}{
+		{"v1 tcp4", "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n", false, "192.168.0.1:56324", "192.168.0.11:443"},
+		{"v1 tcp6", "PROXY TCP6 2001:db8::1 2001:db8::2 4000 8443\r\n", false, "[2001:db8::1]:4000", "[2001:db8::2]:8443"},
+		{"v1 unknown", "PROXY UNKNOWN\r\n", false, origRemote, origLocal},
+		{"v1 unknown with addrs", "PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n", false, origRemote, origLocal},
+		{"v1 missing cr", "PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\n", false, "", ""},
+		{"v1 bad proto", "PROXY UDP4 192.168.0.1 192.168.0.11 56324 443\r\n", false, "", ""},
+		{"v1 bad ip", "PROXY TCP4 192.168.0.300 192.168.0.11 56324 443\r\n", false, "", ""},
+		{"v1 bad port", "PROXY TCP4 192.168.0.1 192.168.0.11 65536 443\r\n", false, "", ""},
+		{"v1 missing field", "PROXY TCP4 192.168.0.1 192.168.0.11 56324\r\n", false, "", ""},
+		{"v1 too long", "PROXY TCP6 " + string(bytes.Repeat([]byte{'1'}, proxyProtocolV1MaxLen)) + "\r\n", false, "", ""},
+
+		{"v2 tcp4", proxyProtocolV2Header(0x21, 0x11, proxyProtocolV2Addrs("192.168.0.1", "192.168.0.11", 56324, 443, nil)), false, "192.168.0.1:56324", "192.168.0.11:443"},
+		{"v2 tcp6", proxyProtocolV2Header(0x21, 0x21, proxyProtocolV2Addrs("2001:db8::1", "2001:db8::2", 4000, 8443, nil)), false, "[2001:db8::1]:4000", "[2001:db8::2]:8443"},
+		{"v2 tlv", proxyProtocolV2Header(0x21, 0x11, proxyProtocolV2Addrs("192.168.0.1", "192.168.0.11", 1, 2, []byte{0x04, 0, 1, 'x'})), false, "192.168.0.1:1", "192.168.0.11:2"},
+		{"v2 local", proxyProtocolV2Header(0x20, 0x00, nil), false, origRemote, origLocal},
+		{"v2 unspec", proxyProtocolV2Header(0x21, 0x00, nil), false, origRemote, origLocal},
+		{"v2 bad version", proxyProtocolV2Header(0x11, 0x11, proxyProtocolV2Addrs("192.168.0.1", "192.168.0.11", 1, 2, nil)), false, "", ""},
+		{"v2 bad command", proxyProtocolV2Header(0x22, 0x11, proxyProtocolV2Addrs("192.168.0.1", "192.168.0.11", 1, 2, nil)), false, "", ""},
+		{"v2 short addrs", proxyProtocolV2Header(0x21, 0x21, proxyProtocolV2Addrs("192.168.0.1", "192.168.0.11", 1, 2, nil)), false, "", ""},
+
+		{"no header optional", "", true, origRemote, origLocal},
+		{"no header required", "", false, "", ""},
+		{"partial signature optional", "PROX", true, origRemote, origLocal},
+	} {
+		data := tc.header + body
+		c := &proxyProtocolTestConn{r: bytes.NewReader([]byte(data))}
+		pc, err := readProxyProtocolHeader(c, tc.optional)
+		if len(tc.remote) == 0 {
+			if err == nil {
+				t.Errorf("%s: expecting error", tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.name, err)
+			continue
+		}
+		if s := pc.RemoteAddr().String(); s != tc.remote {
+			t.Errorf("%s: unexpected RemoteAddr %q, want %q", tc.name, s, tc.remote)
+		}
+		if s := pc.LocalAddr().String(); s != tc.local {
+			t.Errorf("%s: unexpected LocalAddr %q, want %q", tc.name, s, tc.local)
+		}
+		// 头之后的数据(含无头时已预读的数据)不丢失
+		rest, err := io.ReadAll(pc)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.name, err)
+		}
+		want := body
+		if tc.optional { // 无头,原样返回
+			want = data
+		}
+		if string(rest) != want {
+			t.Errorf("%s: unexpected data after header %q, want %q", tc.name, rest, want)
+		}
+	}
+}
+
+func TestReadProxyProtocolHeaderTruncated(t *testing.T) {
+	v2 := proxyProtocolV2Header(0x21, 0x11, proxyProtocolV2Addrs("192.168.0.1", "192.168.0.11", 1, 2, nil))
+	for _, header := range []string{
+		"PROXY TCP4 192.168.0.1",
+		"PROXY ",
+		v2[:16],
+		v2[:20],
+	} {
+		for _, optional := range []bool{false, true} {
+			c := &proxyProtocolTestConn{r: bytes.NewReader([]byte(header))}
+			if _, err := readProxyProtocolHeader(c, optional); err == nil {
+				t.Errorf("%q(optional=%v): expecting error", header, optional)
+			}
+		}
+	}
+}
+
+func TestReadProxyProtocolHeaderUDP(t *testing.T) {
+	hdr := proxyProtocolV2Header(0x21, 0x12, proxyProtocolV2Addrs("192.168.0.1", "192.168.0.11", 53, 5353, nil))
+	c := &proxyProtocolTestConn{r: bytes.NewReader([]byte(hdr))}
+	pc, err := readProxyProtocolHeader(c, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := pc.RemoteAddr().(*net.UDPAddr); !ok {
+		t.Fatalf("unexpected RemoteAddr type %T, want *net.UDPAddr", pc.RemoteAddr())
+	}
+	if s := pc.RemoteAddr().String(); s != "192.168.0.1:53" {
+		t.Fatalf("unexpected RemoteAddr %q", s)
+	}
+}
+
+func TestProxyProtocolListenerTrusted(t *testing.T) {
+	for _, tc := range []struct {
+		trusted []netip.Prefix
+		remote  string
+	}{
+		{nil, "192.168.0.1:56324"},
+		{[]netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}, "192.168.0.1:56324"},
+		{[]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, ""}, // 不可信:不解析头
+	} {
+		inner, err := net.Listen("tcp4", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ln := &ProxyProtocolListener{Listener: inner, Trusted: tc.trusted}
+
+		c, err := net.Dial("tcp4", inner.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Write([]byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n"))
+
+		sc, err := ln.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := tc.remote
+		if len(want) == 0 {
+			want = c.LocalAddr().String()
+		}
+		if s := sc.RemoteAddr().String(); s != want {
+			t.Errorf("trusted %v: unexpected RemoteAddr %q, want %q", tc.trusted, s, want)
+		}
+		sc.Close()
+		c.Close()
+		ln.Close()
+	}
+}
@@ -0,0 +1,108 @@
+package selfFastHttp
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 按客户端的请求限速器-令牌桶算法
+// 见Server.RequestRateLimit
+type requestRateLimiter struct {
+	lock      sync.Mutex
+	m         map[string]*tokenBucket // [客户端标识]-令牌桶
+	lastClean time.Time               // 上次清理时间
+}
+
+type tokenBucket struct {
+	tokens float64   // 剩余令牌数
+	last   time.Time // 上次补充令牌的时间
+}
+
+// 清理满令牌桶的间隔
+const rateLimiterCleanInterval = time.Minute
+
+// 从key的令牌桶取1个令牌
+// 取到返回true;否则返回false，及下个令牌可用的等待时间
+// key可能引用可变内存,新建令牌桶时，复制之
+func (rl *requestRateLimiter) Allow(key string, rate float64, burst int, now time.Time) (bool, time.Duration) {
+	rl.lock.Lock()
+	if rl.m == nil {
+		rl.m = make(map[string]*tokenBucket)
+		rl.lastClean = now
+	}
+	if now.Sub(rl.lastClean) > rateLimiterCleanInterval {
+		rl.clean(rate, burst, now)
+	}
+
+	b := rl.m[key]
+	if b == nil {
+		b = &tokenBucket{
+			tokens: float64(burst),
+			last:   now,
+		}
+		rl.m[string(append([]byte(nil), key...))] = b
+	} else if d := now.Sub(b.last); d > 0 { // now来自各请求的ctx.time,并发时可能乱序;b.last只前移
+		b.tokens += d.Seconds() * rate
+		if b.tokens > float64(burst) {
+			b.tokens = float64(burst)
+		}
+		b.last = now
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		rl.lock.Unlock()
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	rl.lock.Unlock()
+	return false, wait
+}
+
+// 移除已补满的令牌桶:与新建的令牌桶等价，避免map随客户端数量一直增长
+// 须在加锁后调用
+func (rl *requestRateLimiter) clean(rate float64, burst int, now time.Time) {
+	for key, b := range rl.m {
+		d := now.Sub(b.last)
+		if d < 0 {
+			d = 0
+		}
+		if b.tokens+d.Seconds()*rate >= float64(burst) {
+			delete(rl.m, key)
+		}
+	}
+	rl.lastClean = now
+}
+
+// 检测ctx所属客户端是否超出RequestRateLimit
+// 超出时，设置429响应及'Retry-After'头，返回false
+// keyBuf用于生成默认key,返回后可复用
+func (s *Server) allowRequest(ctx *RequestCtx, keyBuf *[]byte) bool {
+	var key string
+	if s.RequestRateKey != nil {
+		key = s.RequestRateKey(ctx)
+	} else {
+		// 默认按RemoteIP
+		*keyBuf = addrToNetIP(ctx.RemoteAddr()).AppendTo((*keyBuf)[:0])
+		key = b2s(*keyBuf)
+	}
+	burst := s.RequestRateBurst
+	if burst <= 0 {
+		burst = int(math.Ceil(s.RequestRateLimit))
+	}
+	ok, wait := s.requestRateLimiter.Allow(key, s.RequestRateLimit, burst, ctx.time)
+	if ok {
+		return true
+	}
+
+	atomic.AddUint64(&s.rejectedRateLimit, 1)
+	ctx.Error("Too many requests", StatusTooManyRequests)
+	retryAfter := int(math.Ceil(wait.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	ctx.Response.Header.SetCanonical(strRetryAfter, AppendUint(nil, retryAfter))
+	return false
+}
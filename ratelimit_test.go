@@ -0,0 +1,31 @@
+package selfFastHttp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRequestRateLimiterAllow(t *testing.T) {
+	start := time.Unix(1000, 0)
+	for _, tc := range []struct {
+		name    string
+		offsets []time.Duration // 相对start的请求时间
+		want    []bool
+	}{
+		{"burst", []time.Duration{0, 0, 0}, []bool{true, true, false}},
+		{"refill", []time.Duration{0, 0, 0, 500 * time.Millisecond}, []bool{true, true, false, true}},
+		{"out of order", []time.Duration{0, 0, time.Second, 500 * time.Millisecond, time.Second}, []bool{true, true, true, true, false}},
+		{"full after idle", []time.Duration{0, 0, 10 * time.Second, 10 * time.Second, 10 * time.Second}, []bool{true, true, true, true, false}},
+	} {
+		var rl requestRateLimiter
+		for i, off := range tc.offsets {
+			ok, wait := rl.Allow("k", 2, 2, start.Add(off))
+			if ok != tc.want[i] {
+				t.Fatalf("%s: request %d: Allow=%v, want %v", tc.name, i, ok, tc.want[i])
+			}
+			if !ok && wait <= 0 {
+				t.Fatalf("%s: request %d: unexpected wait %s", tc.name, i, wait)
+			}
+		}
+	}
+}
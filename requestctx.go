@@ -2,6 +2,7 @@ package selfFastHttp
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io"
@@ -46,6 +47,8 @@ type RequestCtx struct {
 	s      *Server
 	c      net.Conn
 	fbr    firstByteReader //特定条件使用的读取器
+	cio    statsConnIO     //读写缓冲器所用的连接读写器-统计字节数
+	mrr    minRateReader   //cio所用的连接读取器-最小读取速率检测
 
 	timeoutResponse *Response     //超时标志器-用于超时后相关处理
 	timeoutCh       chan struct{} //超时管道,在等待处理结束时，定时
@@ -70,6 +73,11 @@ func (ctx *RequestCtx) Reset() {
 	ctx.s = nil
 	ctx.c = nil
 	ctx.fbr.c = nil
+	ctx.mrr.c = nil
+	ctx.mrr.minRate = 0
+	ctx.cio.c = nil
+	ctx.cio.r = nil
+	ctx.cio.tc = nil
 
 	ctx.timeoutResponse = nil
 	ctx.timeoutCh = nil
@@ -152,7 +160,7 @@ func (ctx *RequestCtx) IsTLS() bool {
 	//
 	//		// other custom fields
 	// }
-	_, ok := ctx.c.(connTLSer)
+	_, ok := tlsConnOf(ctx.c)
 	return ok
 }
 
@@ -160,7 +168,7 @@ func (ctx *RequestCtx) IsTLS() bool {
 // 非tls，返回nil
 // 返回值，可用于确定tls版本,客户端证书
 func (ctx *RequestCtx) TLSConnectionState() *tls.ConnectionState {
-	tlsConn, ok := ctx.c.(connTLSer)
+	tlsConn, ok := tlsConnOf(ctx.c)
 	if !ok {
 		return nil
 	}
@@ -168,12 +176,87 @@ func (ctx *RequestCtx) TLSConnectionState() *tls.ConnectionState {
 	return &state
 }
 
+// 取c的connTLSer接口
+func tlsConnOf(c net.Conn) (connTLSer, bool) {
+	tlsConn, ok := unwrapConn(c, isConnTLSer).(connTLSer)
+	return tlsConn, ok
+}
+
+func isConnTLSer(c net.Conn) bool {
+	_, ok := c.(connTLSer)
+	return ok
+}
+
+// 逐层去掉Server的封装(perIPConn、PROXY protocol、tls探测、tls)，直到until(c)为true
+// until为nil时，取原始连接(如*net.TCPConn、*net.UnixConn)
+func unwrapConn(c net.Conn, until func(net.Conn) bool) net.Conn {
+	for {
+		if until != nil && until(c) {
+			return c
+		}
+		switch x := c.(type) {
+		case *perIPConn:
+			c = x.Conn
+		case *proxyProtocolConn:
+			c = x.Conn
+		case *sniffedConn:
+			c = x.Conn
+		case *tls.Conn:
+			c = x.NetConn()
+		default:
+			return c
+		}
+	}
+}
+
+// 已验证的客户端证书链,第1个为客户端证书
+// 非tls、客户端未提供证书、或证书未经验证(见Server.ClientAuth)，返回nil
+func (ctx *RequestCtx) VerifiedPeerChain() []*x509.Certificate {
+	state := ctx.TLSConnectionState()
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return nil
+	}
+	return state.VerifiedChains[0]
+}
+
+// 已验证的客户端证书的Subject CommonName
+// 无已验证的客户端证书，返回""
+func (ctx *RequestCtx) ClientCertSubjectCN() string {
+	chain := ctx.VerifiedPeerChain()
+	if len(chain) == 0 {
+		return ""
+	}
+	return chain[0].Subject.CommonName
+}
+
+// 已验证的客户端证书的SubjectAltName:DNS名、邮箱、IP、URI
+// 无已验证的客户端证书，返回nil
+func (ctx *RequestCtx) ClientCertSANs() []string {
+	chain := ctx.VerifiedPeerChain()
+	if len(chain) == 0 {
+		return nil
+	}
+	return appendCertSANs(nil, chain[0])
+}
+
+func appendCertSANs(dst []string, cert *x509.Certificate) []string {
+	dst = append(dst, cert.DNSNames...)
+	dst = append(dst, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		dst = append(dst, ip.String())
+	}
+	for _, u := range cert.URIs {
+		dst = append(dst, u.String())
+	}
+	return dst
+}
+
 //===================================
 // 首字节读取器
 // 条件:Server.ReduceMemoryUsage开启 或 最后一次读操作时间超过1秒
 // 目的:初始读缓存时，先读取第1字节;降低ctx使用??
 type firstByteReader struct {
-	c        net.Conn
+	c        io.Reader
 	ch       byte
 	byteRead bool
 }
@@ -591,8 +674,16 @@ func (ctx *RequestCtx) SetBodyStream(bodyStream io.Reader, bodySize int) {
 // * body太大，超过10M
 // * body是从外部慢源取流数据
 // * body需要分片的 - `http client push` `chunked transfer-encoding`
+//
+// sw中的panic由Server恢复:记录到Server.Logger,计入Stats.Panics,随后关闭连接
+// 此时响应头已发送，不调用PanicHandler
 func (ctx *RequestCtx) SetBodyStreamWriter(sw StreamWriter) {
-	ctx.Response.SetBodyStreamWriter(sw)
+	s := ctx.s
+	if s == nil {
+		ctx.Response.SetBodyStreamWriter(sw)
+		return
+	}
+	ctx.Response.SetBodyStream(newStreamReader(sw, s.handleStreamPanic), -1)
 }
 
 func (ctx *RequestCtx) IsBodyStream() bool {
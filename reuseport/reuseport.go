@@ -0,0 +1,23 @@
+// 支持SO_REUSEPORT的监听器
+// 多个监听器共用同一地址，由内核将新连接分摊到各监听器，各自在独立的协程中Accept
+// 仅支持linux(3.9+)
+package reuseport
+
+import (
+	"errors"
+	"net"
+)
+
+// 当前系统不支持SO_REUSEPORT
+var ErrNoReusePort = errors.New("SO_REUSEPORT is not supported on this platform")
+
+// 各监听器须由同一用户创建
+// 未设置SO_REUSEPORT的监听器，占用该地址后，Listen失败
+func Listen(network, addr string) (net.Listener, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, &net.OpError{Op: "listen", Net: network, Err: net.UnknownNetworkError(network)}
+	}
+	return listen(network, addr)
+}
@@ -0,0 +1,31 @@
+//go:build linux
+// +build linux
+
+package reuseport
+
+import (
+	"context"
+	"net"
+	"syscall"
+)
+
+func listen(network, addr string) (net.Listener, error) {
+	lc := net.ListenConfig{
+		Control: control,
+	}
+	return lc.Listen(context.Background(), network, addr)
+}
+
+// 在bind前设置SO_REUSEADDR、SO_REUSEPORT
+func control(network, address string, rc syscall.RawConn) error {
+	var err error
+	if cerr := rc.Control(func(fd uintptr) {
+		if err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err != nil {
+			return
+		}
+		err = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package reuseport
+
+import (
+	"net"
+)
+
+func listen(network, addr string) (net.Listener, error) {
+	return nil, ErrNoReusePort
+}
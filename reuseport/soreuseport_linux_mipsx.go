@@ -0,0 +1,8 @@
+//go:build linux && (mips || mipsle || mips64 || mips64le)
+// +build linux
+// +build mips mipsle mips64 mips64le
+
+package reuseport
+
+// syscall包在部分平台未定义SO_REUSEPORT
+const soReusePort = 0x200
@@ -0,0 +1,91 @@
+package selffasthttputil
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+// Dial/Accept已关闭的InmemoryListener时返回
+var ErrInmemoryListenerClosed = errors.New("InmemoryListener is already closed: use of closed network connection")
+
+// 进程内的net.Listener:Dial返回PipeConns的客户端，Accept返回其服务端
+// 无需打开端口，即可完整运行Server.Serve,用于测试
+// 须通过NewInmemoryListener创建
+type InmemoryListener struct {
+	lock    sync.Mutex
+	closed  bool
+	closeCh chan struct{}
+	conns   chan acceptConn
+}
+
+type acceptConn struct {
+	conn     net.Conn
+	accepted chan struct{}
+}
+
+func NewInmemoryListener() *InmemoryListener {
+	return &InmemoryListener{
+		closeCh: make(chan struct{}),
+		conns:   make(chan acceptConn, 1024),
+	}
+}
+
+// 实现net.Listener接口
+// 返回Dial创建的连接的服务端
+func (ln *InmemoryListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-ln.conns:
+		close(c.accepted)
+		return c.conn, nil
+	case <-ln.closeCh:
+		return nil, ErrInmemoryListenerClosed
+	}
+}
+
+// 关闭后，Accept、Dial返回ErrInmemoryListenerClosed
+func (ln *InmemoryListener) Close() error {
+	var err error
+	ln.lock.Lock()
+	if !ln.closed {
+		close(ln.closeCh)
+		ln.closed = true
+	} else {
+		err = ErrInmemoryListenerClosed
+	}
+	ln.lock.Unlock()
+	return err
+}
+
+func (ln *InmemoryListener) Addr() net.Addr {
+	return pipeAddr(0)
+}
+
+// 创建连接,阻塞至被Accept
+// 返回连接的客户端
+func (ln *InmemoryListener) Dial() (net.Conn, error) {
+	pc := NewPipeConns()
+	cConn := pc.Conn1()
+	sConn := pc.Conn2()
+
+	ln.lock.Lock()
+	if ln.closed {
+		ln.lock.Unlock()
+		return nil, ErrInmemoryListenerClosed
+	}
+	accepted := make(chan struct{})
+	ln.lock.Unlock()
+
+	select {
+	case ln.conns <- acceptConn{sConn, accepted}:
+	case <-ln.closeCh:
+		return nil, ErrInmemoryListenerClosed
+	}
+	select {
+	case <-accepted:
+		return cConn, nil
+	case <-ln.closeCh:
+		pc.Close()
+		return nil, ErrInmemoryListenerClosed
+	}
+}
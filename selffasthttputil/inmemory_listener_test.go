@@ -0,0 +1,126 @@
+package selffasthttputil_test
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/forTWOS/selfFastHttp"
+	"github.com/forTWOS/selfFastHttp/selffasthttputil"
+)
+
+func TestInmemoryListenerDialAccept(t *testing.T) {
+	ln := selffasthttputil.NewInmemoryListener()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(c, c)
+		}
+	}()
+
+	for i := 0; i < 3; i++ {
+		c, err := ln.Dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Write([]byte("ping"))
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "ping" {
+			t.Fatalf("unexpected result %q, %v", buf, err)
+		}
+		c.Close()
+	}
+
+	ln.Close()
+	if _, err := ln.Dial(); err != selffasthttputil.ErrInmemoryListenerClosed {
+		t.Fatalf("unexpected error %v, want %v", err, selffasthttputil.ErrInmemoryListenerClosed)
+	}
+	if err := ln.Close(); err != selffasthttputil.ErrInmemoryListenerClosed {
+		t.Fatalf("unexpected error %v, want %v", err, selffasthttputil.ErrInmemoryListenerClosed)
+	}
+}
+
+func inmemoryGet(t *testing.T, c net.Conn, br *bufio.Reader, path string) string {
+	if _, err := c.Write([]byte("GET " + path + " HTTP/1.1\r\nHost: a\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	var resp selfFastHttp.Response
+	if err := resp.Read(br); err != nil {
+		t.Fatal(err)
+	}
+	return string(resp.Body())
+}
+
+func TestInmemoryListenerServe(t *testing.T) {
+	ln := selffasthttputil.NewInmemoryListener()
+	s := &selfFastHttp.Server{Handler: func(ctx *selfFastHttp.RequestCtx) {
+		ctx.Write(ctx.Path())
+	}}
+	serveCh := make(chan error, 1)
+	go func() { serveCh <- s.Serve(ln) }()
+
+	c, err := ln.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(c)
+	for _, path := range []string{"/a", "/b", "/c"} { // 同一长连接
+		if body := inmemoryGet(t, c, br, path); body != path {
+			t.Fatalf("unexpected body %q, want %q", body, path)
+		}
+	}
+	c.Close()
+
+	ln.Close()
+	select {
+	case err := <-serveCh:
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Serve has not returned after closing the listener")
+	}
+}
+
+func TestInmemoryListenerShutdown(t *testing.T) {
+	ln := selffasthttputil.NewInmemoryListener()
+	s := &selfFastHttp.Server{Handler: func(ctx *selfFastHttp.RequestCtx) {
+		ctx.WriteString("ok")
+	}}
+	serveCh := make(chan error, 1)
+	go func() { serveCh <- s.Serve(ln) }()
+
+	// 闲置的长连接:Shutdown须唤醒阻塞在读请求上的连接
+	var conns []net.Conn
+	for i := 0; i < 3; i++ {
+		c, err := ln.Dial()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body := inmemoryGet(t, c, bufio.NewReader(c), "/"); body != "ok" {
+			t.Fatalf("unexpected body %q", body)
+		}
+		conns = append(conns, c)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := <-serveCh; err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for _, c := range conns {
+		c.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+			t.Fatalf("unexpected error %v, want %v", err, io.EOF)
+		}
+	}
+}
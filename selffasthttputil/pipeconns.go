@@ -36,6 +36,7 @@ type PipeConns struct {
 	c2         pipeConn
 	stopCh     chan struct{}
 	stopChLock sync.Mutex
+	err        error // 关闭原因,见CloseWithError
 }
 
 // 返回首端-双向通道
@@ -51,10 +52,18 @@ func (pc *PipeConns) Conn2() net.Conn {
 
 // 关闭双向管道
 func (pc *PipeConns) Close() error {
+	return pc.CloseWithError(nil)
+}
+
+// 关闭双向管道
+// 读完已写入的数据后，Read返回err;err为nil时，返回io.EOF
+// 已关闭时，不改变关闭原因
+func (pc *PipeConns) CloseWithError(err error) error {
 	pc.stopChLock.Lock()
 	select {
 	case <-pc.stopCh: //检测是否已关闭
 	default:
+		pc.err = err
 		close(pc.stopCh)
 	}
 	pc.stopChLock.Unlock()
@@ -70,11 +79,52 @@ type pipeConn struct {
 	wCh chan *byteBuffer //写通道
 	pc  *PipeConns
 
-	readDeadlineTimer  *time.Timer //读超时器
-	writeDeadlineTimer *time.Timer //写超时器
+	readDeadline  pipeDeadline // 读超时
+	writeDeadline pipeDeadline // 写超时
+}
+
+// 读/写超时
+// Set*Deadline可与阻塞的Read/Write并发调用:修改超时时唤醒阻塞者，使其改用新的超时
+type pipeDeadline struct {
+	lock   sync.Mutex
+	timer  *time.Timer
+	ch     <-chan time.Time // 超时信号器,nil:无超时
+	wakeCh chan struct{}    // 阻塞者等待其关闭,由set关闭
+}
+
+// 设置超时,唤醒阻塞者
+func (d *pipeDeadline) set(deadline time.Time) {
+	d.lock.Lock()
+	if d.timer == nil {
+		d.timer = time.NewTimer(time.Hour)
+	}
+	d.ch = updateTimer(d.timer, deadline)
+	if d.wakeCh != nil {
+		close(d.wakeCh)
+		d.wakeCh = nil
+	}
+	d.lock.Unlock()
+}
 
-	readDeadlineCh  <-chan time.Time // 读超时信号器
-	writeDeadlineCh <-chan time.Time // 写超时信号器
+// 返回当前的超时信号器，及超时被修改时关闭的通道
+// 阻塞前调用;被唤醒后，须重新调用
+func (d *pipeDeadline) wait() (<-chan time.Time, <-chan struct{}) {
+	d.lock.Lock()
+	if d.wakeCh == nil {
+		d.wakeCh = make(chan struct{})
+	}
+	ch, wakeCh := d.ch, d.wakeCh
+	d.lock.Unlock()
+	return ch, wakeCh
+}
+
+// ch已超时:其信号仅可读取一次,此后使用已关闭的信号器,直到超时被修改
+func (d *pipeDeadline) expire(ch <-chan time.Time) {
+	d.lock.Lock()
+	if d.ch == ch {
+		d.ch = closedDeadlineCh
+	}
+	d.lock.Unlock()
 }
 
 // 实现io.Write接口
@@ -91,19 +141,24 @@ func (c *pipeConn) Write(p []byte) (int, error) {
 
 	select {
 	case c.wCh <- b: // 传数据到写通道
+		return len(p), nil
 	default: // 若无法传入(缓存区满)
+	}
+	for {
+		deadlineCh, wakeCh := c.writeDeadline.wait()
 		select {
 		case c.wCh <- b: // 传数据到写通道
-		case <-c.writeDeadlineCh: // 写超时
-			c.writeDeadlineCh = closedDeadlineCh
+			return len(p), nil
+		case <-deadlineCh: // 写超时
+			c.writeDeadline.expire(deadlineCh)
+			releaseByteBuffer(b)
 			return 0, ErrTimeout
+		case <-wakeCh: // 超时被修改
 		case <-c.pc.stopCh: // 双向通道关闭
 			releaseByteBuffer(b)
 			return 0, errConnectionClosed
 		}
 	}
-
-	return len(p), nil
 }
 
 // --- Read
@@ -149,22 +204,29 @@ func (c *pipeConn) readNextByteBuffer(mayBlock bool) error {
 			return errWouldBlock
 		}
 		// 阻塞处理
-		select {
-		case c.b = <-c.rCh:
-		case <-c.readDeadlineCh: // 读超时
-			c.readDeadlineCh = closedDeadlineCh
-			// 在超时时，rCh有可能已读取到数据，需处理之
+		for c.b == nil {
+			deadlineCh, wakeCh := c.readDeadline.wait()
 			select {
 			case c.b = <-c.rCh:
-			default:
-				return ErrTimeout
-			}
-		case <-c.pc.stopCh: // 双向通道关闭
-			// 在超时时，rCh有可能已读取到数据，需处理之
-			select {
-			case c.b = <-c.rCh:
-			default:
-				return io.EOF
+			case <-deadlineCh: // 读超时
+				c.readDeadline.expire(deadlineCh)
+				// 在超时时，rCh有可能已读取到数据，需处理之
+				select {
+				case c.b = <-c.rCh:
+				default:
+					return ErrTimeout
+				}
+			case <-wakeCh: // 超时被修改,按新的超时等待
+			case <-c.pc.stopCh: // 双向通道关闭
+				// 在超时时，rCh有可能已读取到数据，需处理之
+				select {
+				case c.b = <-c.rCh:
+				default:
+					if c.pc.err != nil {
+						return c.pc.err
+					}
+					return io.EOF
+				}
 			}
 		}
 	}
@@ -178,9 +240,16 @@ var (
 	errConnectionClosed = errors.New("connection closed")
 
 	// 读/写 超时
-	ErrTimeout = errors.New("timeout")
+	// 实现net.Error,Timeout()为true,与net.Conn的超时错误一致
+	ErrTimeout error = timeoutError{}
 )
 
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
 func (c *pipeConn) Close() error {
 	return c.pc.Close()
 }
@@ -199,18 +268,15 @@ func (c *pipeConn) SetDeadline(deadline time.Time) error {
 	return nil
 }
 
+// 可与阻塞的Read并发调用,Read按新的超时返回
 func (c *pipeConn) SetReadDeadline(deadline time.Time) error {
-	if c.readDeadlineTimer == nil {
-		c.readDeadlineTimer = time.NewTimer(time.Hour)
-	}
-	c.readDeadlineCh = updateTimer(c.readDeadlineTimer, deadline)
+	c.readDeadline.set(deadline)
 	return nil
 }
+
+// 可与阻塞的Write并发调用,Write按新的超时返回
 func (c *pipeConn) SetWriteDeadline(deadline time.Time) error {
-	if c.writeDeadlineTimer == nil {
-		c.writeDeadlineTimer = time.NewTimer(time.Hour)
-	}
-	c.writeDeadlineCh = updateTimer(c.writeDeadlineTimer, deadline)
+	c.writeDeadline.set(deadline)
 	return nil
 }
 func updateTimer(t *time.Timer, deadline time.Time) <-chan time.Time {
@@ -0,0 +1,174 @@
+package selffasthttputil
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPipeConnsReadWrite(t *testing.T) {
+	pc := NewPipeConns()
+	defer pc.Close()
+	c1, c2 := pc.Conn1(), pc.Conn2()
+
+	for _, conns := range [][2]net.Conn{{c1, c2}, {c2, c1}} {
+		w, r := conns[0], conns[1]
+		for _, s := range []string{"foo", "bar baz"} {
+			if _, err := w.Write([]byte(s)); err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, 64)
+			n, err := r.Read(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(buf[:n]) != s {
+				t.Fatalf("unexpected data %q, want %q", buf[:n], s)
+			}
+		}
+	}
+}
+
+// 在协程中Read,返回其结果
+func pipeConnsReadAsync(c net.Conn) <-chan error {
+	ch := make(chan error, 1)
+	go func() {
+		var buf [16]byte
+		_, err := c.Read(buf[:])
+		ch <- err
+	}()
+	return ch
+}
+
+func TestPipeConnsReadDeadline(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		set  func(c net.Conn)
+		err  error // nil:不超时，读到数据
+	}{
+		{"past", func(c net.Conn) {
+			c.SetReadDeadline(time.Now().Add(-time.Second))
+		}, ErrTimeout},
+		{"short", func(c net.Conn) {
+			c.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+		}, ErrTimeout},
+		{"cleared", func(c net.Conn) {
+			c.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+			c.SetReadDeadline(time.Time{})
+		}, nil},
+	} {
+		pc := NewPipeConns()
+		c1, c2 := pc.Conn1(), pc.Conn2()
+		ch := pipeConnsReadAsync(c2)
+		time.Sleep(10 * time.Millisecond) // Read已阻塞
+		// 修改超时时，阻塞的Read按新的超时返回
+		tc.set(c2)
+		select {
+		case err := <-ch:
+			if tc.err == nil {
+				t.Fatalf("%s: unexpected error %v", tc.name, err)
+			}
+			if err != tc.err {
+				t.Fatalf("%s: unexpected error %v, want %v", tc.name, err, tc.err)
+			}
+		case <-time.After(100 * time.Millisecond):
+			if tc.err != nil {
+				t.Fatalf("%s: Read is still blocked", tc.name)
+			}
+			c1.Write([]byte("x"))
+			if err := <-ch; err != nil {
+				t.Fatalf("%s: unexpected error %v", tc.name, err)
+			}
+		}
+		pc.Close()
+	}
+}
+
+func TestPipeConnsReadDeadlineExtend(t *testing.T) {
+	pc := NewPipeConns()
+	defer pc.Close()
+	c1, c2 := pc.Conn1(), pc.Conn2()
+
+	c2.SetReadDeadline(time.Now().Add(-time.Second))
+	var buf [16]byte
+	if _, err := c2.Read(buf[:]); err != ErrTimeout {
+		t.Fatalf("unexpected error %v, want %v", err, ErrTimeout)
+	}
+	if _, err := c2.Read(buf[:]); err != ErrTimeout {
+		t.Fatalf("unexpected error %v, want %v", err, ErrTimeout)
+	}
+	// 超时后，延长超时，可继续读取
+	c2.SetReadDeadline(time.Now().Add(time.Second))
+	c1.Write([]byte("x"))
+	if n, err := c2.Read(buf[:]); err != nil || string(buf[:n]) != "x" {
+		t.Fatalf("unexpected result %q, %v", buf[:n], err)
+	}
+}
+
+func TestPipeConnsWriteDeadline(t *testing.T) {
+	pc := NewPipeConns()
+	defer pc.Close()
+	c1 := pc.Conn1()
+
+	// 对端不读取，缓冲满后，Write阻塞
+	ch := make(chan error, 1)
+	go func() {
+		for {
+			if _, err := c1.Write([]byte("x")); err != nil {
+				ch <- err
+				return
+			}
+		}
+	}()
+	time.Sleep(10 * time.Millisecond)
+	c1.SetWriteDeadline(time.Now().Add(-time.Second))
+	select {
+	case err := <-ch:
+		if err != ErrTimeout {
+			t.Fatalf("unexpected error %v, want %v", err, ErrTimeout)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Write is still blocked")
+	}
+}
+
+func TestPipeConnsClose(t *testing.T) {
+	errCustom := errors.New("custom")
+	for _, tc := range []struct {
+		err  error
+		want error
+	}{
+		{nil, io.EOF},
+		{errCustom, errCustom},
+	} {
+		pc := NewPipeConns()
+		c1, c2 := pc.Conn1(), pc.Conn2()
+		c1.Write([]byte("x"))
+		ch := pipeConnsReadAsync(c2)
+		if err := <-ch; err != nil {
+			t.Fatal(err)
+		}
+		ch = pipeConnsReadAsync(c2)
+		time.Sleep(10 * time.Millisecond)
+		pc.CloseWithError(tc.err)
+		if err := <-ch; err != tc.want {
+			t.Fatalf("unexpected error %v, want %v", err, tc.want)
+		}
+		if _, err := c1.Write([]byte("x")); err == nil {
+			t.Fatalf("expecting error when writing to closed conn")
+		}
+	}
+}
+
+func TestPipeConnsTimeoutError(t *testing.T) {
+	pc := NewPipeConns()
+	c := pc.Conn1()
+	defer pc.Close()
+	c.SetReadDeadline(time.Now().Add(-time.Second))
+	_, err := c.Read(make([]byte, 1))
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Fatalf("unexpected error %v, want net.Error with Timeout() true", err)
+	}
+}
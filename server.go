@@ -2,7 +2,11 @@
 package selfFastHttp
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"net"
+	"net/netip"
 	"os"
 	"sync"
 	"sync/atomic"
@@ -103,6 +107,93 @@ type Server struct {
 	//一个server的并发数
 	Concurrency int
 
+	// 同时服务的最大连接数,所有监听器合计
+	// 与Concurrency(每个Serve的服务协程数)相互独立,超出时按OverloadPolicy处理
+	// 默认无限制
+	MaxConns int
+
+	// 满载(Concurrency 或 MaxConns)时，新连接的处理方式
+	// 默认OverloadReject
+	OverloadPolicy OverloadPolicy
+
+	// OverloadQueue模式下，排队的连接等待空闲服务协程的最长时间
+	// 超时后按OverloadReject处理
+	// 默认100ms
+	OverloadQueueTimeout time.Duration
+
+	// OverloadReject模式下的响应码
+	// 默认StatusServiceUnavailable
+	OverloadStatusCode int
+
+	// OverloadReject模式下的响应内容
+	// 默认"The connection cannot be served because Server.Concurrency(或MaxConns) limit exceeded"
+	OverloadMessage string
+
+	// 所有ListenAndServeTLS*,ServeTLS*使用的tls配置
+	// 使用其副本，并追加AppendCert*及参数指定的证书;不修改该配置，可与其它Server共用
+	// 多证书时按SNI选择,见AppendCert
+	// 可设置GetCertificate实现证书热更新,见CertReloader
+	//
+	// 默认仅使用参数指定的证书
+	TLSConfig *tls.Config
+
+	// ServeTLS*端口上，明文http请求的处理方式
+	// 非TLSPlaintextOff时，按连接首字节区分tls与明文,见TLSPlaintextMode
+	// 默认TLSPlaintextOff
+	TLSPlaintext TLSPlaintextMode
+
+	// TLSPlaintext模式下，等待连接首字节(区分tls与明文)的超时时间
+	// 默认DefaultTLSSniffTimeout
+	TLSSniffTimeout time.Duration
+
+	// 验证客户端证书的CA(mTLS)
+	// 设置后，覆盖TLSConfig.ClientCAs
+	// 默认使用TLSConfig.ClientCAs
+	ClientCAs *x509.CertPool
+
+	// 客户端证书的验证方式，如tls.RequireAndVerifyClientCert
+	// 非零值时，覆盖TLSConfig.ClientAuth
+	// 默认使用TLSConfig.ClientAuth;其未设置而设置了ClientCAs时，使用tls.RequireAndVerifyClientCert
+	ClientAuth tls.ClientAuthType
+
+	// ListenAndServeUNIX创建的socket文件的所有者,用户名或uid
+	// 默认不修改
+	UnixSocketOwner string
+
+	// ListenAndServeUNIX创建的socket文件的所属组,组名或gid
+	// 默认不修改
+	UnixSocketGroup string
+
+	// 连接的TCP keepalive探测间隔,用于及时发现已断开的对端(如NAT后)
+	// <0时，关闭keepalive
+	// 默认使用go的默认值(15秒)
+	TCPKeepalivePeriod time.Duration
+
+	// 关闭TCP_NODELAY,合并小包发送(Nagle算法)
+	// 默认开启TCP_NODELAY
+	DisableTCPNoDelay bool
+
+	// TCP_DEFER_ACCEPT:客户端发送数据后，才接受连接,按秒取整
+	// 仅linux,ListenAndServe*创建的监听器
+	// 默认不设置
+	TCPDeferAccept time.Duration
+
+	// TCP_FASTOPEN的队列长度
+	// 仅linux,ListenAndServe*创建的监听器
+	// 默认不开启
+	TCPFastOpen int
+
+	// 连接的SO_RCVBUF,SO_SNDBUF大小
+	// 默认使用系统值
+	SocketReadBuffer  int
+	SocketWriteBuffer int
+
+	// TCP_USER_TIMEOUT:已发送数据未被确认的最长时间,超时后内核关闭连接
+	// 与TCPKeepalivePeriod合用，可尽快释放死连接(及MaxConnsPerIP计数)
+	// 仅linux
+	// 默认使用系统值
+	TCPUserTimeout time.Duration
+
 	// 是否不使用长连接
 	//
 	// The server will close all the incoming connections after sending
@@ -124,19 +215,80 @@ type Server struct {
 
 	// 读取1个请求数据(包括body)的等待时间
 	//
-	// 对闲置长连接生效 //todo
+	// 未设置IdleTimeout时，同样用于长连接等待下一请求
 	// By default 无限制
 	ReadTimeout time.Duration
 
+	// 长连接等待下一请求的最大闲置时间
+	// 收到下一请求的首字节后，改用ReadTimeout
+	//
+	// 默认使用ReadTimeout
+	IdleTimeout time.Duration
+
+	// 读取请求头部的最大时间
+	// 长连接上的后续请求，从收到首字节开始计
+	// 用于防慢速攻击(slowloris):逐字节发送头部的连接，不会一直占用服务协程直到ReadTimeout
+	// 默认无限制(仅受ReadTimeout限制)
+	HeaderReadTimeout time.Duration
+
+	// 读取请求body的最小速率(字节/秒)
+	// 从开始读取body计，平均速率低于该值的连接将超时,另有1秒宽限
+	// 慢速但持续的上传不受影响，停滞的上传将被断开
+	// 默认无限制
+	MinRequestBodyRate int
+
 	// 写操作超时时间(包括body)
 	// 默认无限制
 	WriteTimeout time.Duration
 
 	// 针对ip限制并发最大连接数
+	// IPv4、IPv6都生效,按ip前缀聚合计数,见MaxConnsPerIPv4Prefix、MaxConnsPerIPv6Prefix
 	//
 	// 默认无限制
 	MaxConnsPerIP int
 
+	// MaxConnsPerIP计数时，IPv4地址的聚合前缀长度
+	// 如24:同一/24网段的连接合并计数
+	// 默认32,即按单个ip计数
+	MaxConnsPerIPv4Prefix int
+
+	// MaxConnsPerIP计数时，IPv6地址的聚合前缀长度
+	// 默认64:通常一台主机/一个用户拥有整个/64网段
+	MaxConnsPerIPv6Prefix int
+
+	// 不受MaxConnsPerIP限制的网段,如内部服务、负载均衡器
+	// 默认为空
+	MaxConnsPerIPExempt []netip.Prefix
+
+	// 位于L4负载均衡器后时，解析连接的PROXY protocol v1/v2头
+	// 连接的RemoteAddr为原始客户端地址,RemoteIP、MaxConnsPerIP等随之生效
+	// 见ProxyProtocolListener
+	// 默认ProxyProtocolOff
+	ProxyProtocol ProxyProtocolMode
+
+	// 可信的PROXY protocol来源(负载均衡器)地址,仅解析来自这些地址的连接的头
+	// 默认信任所有来源
+	ProxyProtocolTrusted []netip.Prefix
+
+	// 读取PROXY protocol头的超时时间
+	// 默认DefaultProxyProtocolHeaderTimeout
+	ProxyProtocolHeaderTimeout time.Duration
+
+	// 每个客户端的请求速率限制(请求数/秒),令牌桶算法
+	// 在调用Handler前检测,超出时响应StatusTooManyRequests及'Retry-After'头
+	// 与MaxConnsPerIP不同，同样限制长连接上的请求
+	//
+	// 默认无限制
+	RequestRateLimit float64
+
+	// 令牌桶容量，即允许的突发请求数
+	// 默认为RequestRateLimit(向上取整)
+	RequestRateBurst int
+
+	// 返回客户端标识，作为限速的key,如api key、用户id
+	// 默认使用RemoteIP
+	RequestRateKey func(ctx *RequestCtx) string
+
 	// 每个连接的最大请求数(使用次数)
 	//
 	// 当最后一个请求结束，将关闭连接
@@ -182,18 +334,156 @@ type Server struct {
 	// 默认使用log包
 	Logger Logger
 
+	// 连接状态变化时的回调
+	// 可用于统计连接数、按需关闭闲置长连接等
+	// 回调在服务该连接的协程中同步调用，须尽快返回
+	// 每个请求的顺序:读到请求首字节时StateActive(此时请求头、body尚未读完),响应写完后StateIdle
+	// 默认不回调
+	ConnState func(net.Conn, ConnState)
+
+	// 读取、解析请求出错时的回调,用于自定义错误响应(如json格式)
+	// err的类型:
+	//   *ErrSmallBuffer - header超过ReadBufferSize,建议StatusRequestHeaderFieldsTooLarge
+	//   ErrBodyTooLarge - body超过MaxRequestBodySize,建议StatusRequestEntityTooLarge
+	//   *ErrReadTimeout - 读取超时,建议StatusRequestTimeout
+	//   *ErrBadRequest  - 请求行、header等格式错误,建议StatusBadRequest
+	// 响应后连接关闭
+	//
+	// 默认按上述建议响应纯文本错误信息
+	ErrorHandler func(ctx *RequestCtx, err error)
+
+	// Handler panic时的回调:调用栈尚未展开，可用debug.Stack()取得
+	// 可设置ctx的响应(如StatusInternalServerError),响应后连接关闭
+	// 须不再panic
+	// 劫持处理接口、RequestCtx.SetBodyStreamWriter中的panic，仅记录日志
+	//
+	// 默认记录panic及调用栈，并响应StatusInternalServerError
+	PanicHandler func(ctx *RequestCtx, recovered interface{})
+
 	concurrency      uint32           //当前并发数，有请求时，与Concurrency比对
 	concurrencyCh    chan struct{}    //限制并发数手段:能写入struct{}{}时，表示获得1个服务数,使用完取出下标志
 	perIPConnCounter perIPConnCounter //每个ip的连接计数器
 	serverName       atomic.Value     //实际使用的服务器名 响应时，填入
 
+	requestRateLimiter requestRateLimiter //每个客户端的请求限速器
+
+	certsMu sync.Mutex        // 保护certs
+	certs   []tls.Certificate // AppendCert*追加的证书
+
 	ctxPool        sync.Pool //请求的上下文池
 	readerPool     sync.Pool //请求的写缓存区池
 	writerPool     sync.Pool //请求的读缓存区池
 	hijackConnPool sync.Pool //被劫持的连接池
 	bytePool       sync.Pool //字节分片池-用于读字节
+
+	// 优雅关闭
+	mu          sync.Mutex                // 保护ln
+	ln          []net.Listener            // Serve中的监听器,Shutdown时关闭
+	open        int32                     // 正在服务的连接数,含排队中的
+	queuedConns int32                     // OverloadQueue模式下排队中的连接数
+	stop        int32                     // 1:已调用Shutdown
+	connsMu     sync.Mutex                // 保护conns
+	conns       map[net.Conn]*trackedConn // 服务中的连接,Shutdown时立即关闭其中的闲置连接
+
+	// 运行统计,见Stats
+	wpLock              sync.Mutex    // 保护wps
+	wps                 []*workerPool // 各Serve中的服务池
+	requestsServed      uint64        // 已处理的请求数
+	bytesRead           uint64        // 读取的字节数
+	bytesWritten        uint64        // 写入的字节数
+	hijackedConns       uint64        // 被劫持的连接数
+	rejectedConcurrency uint64        // 因Concurrency满载,被拒绝的连接数
+	rejectedMaxConns    uint64        // 因MaxConns,被拒绝的连接数
+	rejectedPerIP       uint64        // 因MaxConnsPerIP,被拒绝的连接数
+	rejectedRateLimit   uint64        // 因RequestRateLimit,被拒绝的请求数
+	panics              uint64        // 恢复的panic数
+}
+
+// 满载时新连接的处理方式,见Server.OverloadPolicy
+type OverloadPolicy int
+
+const (
+	// 响应OverloadStatusCode、OverloadMessage后，关闭连接
+	OverloadReject OverloadPolicy = iota
+
+	// 新连接排队等待空闲服务协程,最多等待OverloadQueueTimeout;不影响接收其它连接
+	// 排队的连接数最多为Concurrency,超出时按OverloadReject处理
+	// 适合短时突发的满载
+	OverloadQueue
+
+	// 不响应，直接关闭连接
+	OverloadClose
+)
+
+// 连接状态,见Server.ConnState
+type ConnState int
+
+const (
+	// 新接收的连接,还未读取到请求
+	StateNew ConnState = iota
+
+	// 已读到请求的首字节,正在读取、处理请求(同net/http)
+	// 处理完成后,转为StateIdle 或 StateClosed
+	StateActive
+
+	// 长连接,已完成上一请求,等待下一请求
+	StateIdle
+
+	// 被劫持,不再由Server管理,不再有后续状态
+	StateHijacked
+
+	// 已关闭
+	StateClosed
+)
+
+var connStateName = map[ConnState]string{
+	StateNew:      "new",
+	StateActive:   "active",
+	StateIdle:     "idle",
+	StateHijacked: "hijacked",
+	StateClosed:   "closed",
+}
+
+func (c ConnState) String() string {
+	return connStateName[c]
 }
 
+// 优雅关闭server
+// 1.关闭所有监听器,不再接收新连接
+// 2.直接关闭等待请求中的闲置连接
+// 3.处理中的请求继续完成,其响应加上'Connection: close'头,随后关闭连接
+// 所有连接关闭后返回nil;ctx先到期,则返回ctx.Err()
+// Serve在Shutdown后返回nil
+// Shutdown后不可再复用该Server
+// 可与Serve、其它Shutdown并发调用
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	atomic.StoreInt32(&s.stop, 1)
+	for _, ln := range s.ln {
+		ln.Close()
+	}
+	s.ln = nil
+	s.mu.Unlock()
+
+	// 处理中的连接,在响应完成后进入闲置状态,需循环关闭
+	t := time.NewTicker(shutdownPollInterval)
+	defer t.Stop()
+	for {
+		s.closeIdleConns()
+		if atomic.LoadInt32(&s.open) == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// Shutdown中检测连接数的间隔
+const shutdownPollInterval = 100 * time.Millisecond
+
 // 生成定时请求处理器-当h处理超时时，将StatusRequestTimeout发给客户端
 // 生成的处理器，在并发满载时，会响应StatusTooManyRequests
 func TimeoutHandler(h RequestHandler, timeout time.Duration, msg string) RequestHandler {
@@ -216,9 +506,15 @@ func TimeoutHandler(h RequestHandler, timeout time.Duration, msg string) Request
 			ctx.timeoutCh = ch
 		}
 		go func() {
+			defer func() {
+				if r := recover(); r != nil {
+					// 超时前，调用者等待ch;超时后，ctx归属该协程;都可安全修改ctx
+					ctx.s.handlePanic(ctx, r)
+				}
+				ch <- struct{}{}
+				<-concurrencyCh //还回并发处理
+			}()
 			h(ctx)
-			ch <- struct{}{}
-			<-concurrencyCh //还回并发处理
 		}()
 		ctx.timeoutTimer = initTimer(ctx.timeoutTimer, timeout)
 		select {
@@ -230,6 +526,37 @@ func TimeoutHandler(h RequestHandler, timeout time.Duration, msg string) Request
 	}
 }
 
+// 生成客户端证书检测处理器(mTLS)
+// 已验证的客户端证书的Subject CommonName或任一SubjectAltName在allowedSubjects中时，调用h
+// 否则响应StatusForbidden
+// 客户端证书的验证，见Server.ClientCAs、Server.ClientAuth
+func ClientCertHandler(h RequestHandler, allowedSubjects []string) RequestHandler {
+	allowed := make(map[string]struct{}, len(allowedSubjects))
+	for _, subject := range allowedSubjects {
+		allowed[subject] = struct{}{}
+	}
+	return func(ctx *RequestCtx) {
+		chain := ctx.VerifiedPeerChain()
+		if len(chain) > 0 && certSubjectAllowed(chain[0], allowed) {
+			h(ctx)
+			return
+		}
+		ctx.Error("Client certificate is not allowed", StatusForbidden)
+	}
+}
+
+func certSubjectAllowed(cert *x509.Certificate, allowed map[string]struct{}) bool {
+	if _, ok := allowed[cert.Subject.CommonName]; ok && len(cert.Subject.CommonName) > 0 {
+		return true
+	}
+	for _, san := range appendCertSANs(nil, cert) {
+		if _, ok := allowed[san]; ok {
+			return true
+		}
+	}
+	return false
+}
+
 // 有'gzip' or 'deflate' 'Accept-Encoding'头时，将压缩h生成的响应内容
 func CompressHandler(h RequestHandler) RequestHandler {
 	return CompressHandlerLevel(h, CompressDefaultCompression)
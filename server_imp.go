@@ -3,43 +3,125 @@ package selfFastHttp
 import (
 	"bufio"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
+	"runtime"
+	"runtime/debug"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/forTWOS/selfFastHttp/reuseport"
 )
 
 func (s *Server) ListenAndServe(addr string) error {
-	ln, err := net.Listen("tcp4", addr)
+	ln, err := s.listenTCP4(addr)
 	if err != nil {
 		return err
 	}
 	return s.Serve(ln)
 }
 
-// 先移除 该地址上的文件  todo??
+// 监听addr,并按Server设置socket选项
+func (s *Server) listenTCP4(addr string) (net.Listener, error) {
+	ln, err := net.Listen("tcp4", addr)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.tuneListener(ln); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// 在addr上创建n个SO_REUSEPORT监听器，共用该Server服务
+// 由内核将新连接分摊到各监听器，各自Accept,避免单个Accept循环成为瓶颈
+// n<=0时，使用runtime.GOMAXPROCS(0)
+// addr的端口为0时，各监听器共用系统分配的同一端口
+// 每个监听器有独立的服务协程池,Concurrency对每个监听器分别生效;MaxConns为合计
+// 任一监听器出错时，关闭其它监听器，返回首个错误
+// 仅支持linux,见reuseport包
+func (s *Server) ListenAndServeMulti(addr string, n int) error {
+	if n <= 0 {
+		n = runtime.GOMAXPROCS(0)
+	}
+	lns := make([]net.Listener, 0, n)
+	for i := 0; i < n; i++ {
+		ln, err := reuseport.Listen("tcp4", addr)
+		if err == nil {
+			if err = s.tuneListener(ln); err != nil {
+				ln.Close()
+			}
+		}
+		if err != nil {
+			for _, ln := range lns {
+				ln.Close()
+			}
+			return err
+		}
+		lns = append(lns, ln)
+		if i == 0 { // 端口为0时，其余监听器使用首个监听器分配的端口
+			addr = ln.Addr().String()
+		}
+	}
+
+	errCh := make(chan error, n)
+	for _, ln := range lns {
+		go func(ln net.Listener) {
+			errCh <- s.Serve(ln)
+		}(ln)
+	}
+	var err error
+	for i := 0; i < n; i++ {
+		if e := <-errCh; e != nil && err == nil {
+			err = e
+			for _, ln := range lns {
+				ln.Close()
+			}
+		}
+	}
+	return err
+}
+
+// 监听unix socket文件addr,设置其权限mode,及UnixSocketOwner,UnixSocketGroup
+// socket文件在设置完成后才出现在addr,此前其它用户无法连接
+// addr上已有socket文件、且无进程在监听时，视为残留文件，先移除;有进程监听或非socket文件时，返回错误
+// addr以'@'开头时，为linux抽象命名空间地址，无对应文件，忽略mode等
+// 返回时(如Shutdown后)，移除socket文件
 func (s *Server) ListenAndServeUNIX(addr string, mode os.FileMode) error {
-	if err := os.Remove(addr); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("unexpected error when trying to remove unix socket file %q: %s", addr, err)
+	if strings.HasPrefix(addr, "@") {
+		ln, err := net.Listen("unix", addr)
+		if err != nil {
+			return err
+		}
+		defer ln.Close()
+		return s.Serve(ln)
 	}
-	ln, err := net.Listen("unix", addr)
-	if err != nil {
+
+	if err := removeStaleUnixSocket(addr); err != nil {
 		return err
 	}
-	if err = os.Chmod(addr, mode); err != nil {
-		return fmt.Errorf("cannot chmod %#o for %q: %s", mode, addr, err)
+	ln, err := listenUnixSocket(addr, mode, s.UnixSocketOwner, s.UnixSocketGroup)
+	if err != nil {
+		return err
 	}
+	defer func() {
+		ln.Close()
+		os.Remove(addr) // 移除socket文件
+	}()
 	return s.Serve(ln)
 }
 
 // HTTPS requests
 func (s *Server) ListenAndServeTLS(addr, certFile, keyFile string) error {
-	ln, err := net.Listen("tcp4", addr)
+	ln, err := s.listenTCP4(addr)
 	if err != nil {
 		return err
 	}
@@ -48,95 +130,286 @@ func (s *Server) ListenAndServeTLS(addr, certFile, keyFile string) error {
 
 // HTTPS requests
 func (s *Server) ListenAndServeTLSEmbed(addr string, certData, keyData []byte) error {
-	ln, err := net.Listen("tcp4", addr)
+	ln, err := s.listenTCP4(addr)
 	if err != nil {
 		return err
 	}
 	return s.ServeTLSEmbed(ln, certData, keyData)
 }
 
+// 证书从certFile,keyFile加载,追加到TLSConfig的证书中
+// certFile,keyFile都为空时，仅使用TLSConfig(如GetCertificate,见CertReloader)
 func (s *Server) ServeTLS(ln net.Listener, certFile, keyFile string) error {
-	lnTLS, err := newTLSListener(ln, certFile, keyFile) //嵌套tls
+	lnTLS, err := s.newTLSListener(s.newProxyProtocolListener(ln), certFile, keyFile) //嵌套tls
 	if err != nil {
 		return err
 	}
-	return s.Serve(lnTLS)
+	return s.serve(lnTLS)
 }
+// 同ServeTLS,证书、私钥为pem数据
+// certData,keyData都为空时，仅使用TLSConfig
 func (s *Server) ServeTLSEmbed(ln net.Listener, certData, keyData []byte) error {
-	lnTLS, err := newTLSListenerEmbed(ln, certData, keyData)
+	lnTLS, err := s.newTLSListenerEmbed(s.newProxyProtocolListener(ln), certData, keyData)
 	if err != nil {
 		return err
 	}
-	return s.Serve(lnTLS)
+	return s.serve(lnTLS)
 }
-func newTLSListener(ln net.Listener, certFile, keyFile string) (net.Listener, error) {
+func (s *Server) newTLSListener(ln net.Listener, certFile, keyFile string) (net.Listener, error) {
+	if len(certFile) == 0 && len(keyFile) == 0 {
+		return s.newCertListener(ln, nil)
+	}
 	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
 	if err != nil {
 		return nil, fmt.Errorf("cannot load TLS key pair from certFile=%q and keyFile=%q: %s", certFile, keyFile, err)
 	}
-	return newCertListener(ln, &cert), nil
+	return s.newCertListener(ln, &cert)
 }
-func newTLSListenerEmbed(ln net.Listener, certData, keyData []byte) (net.Listener, error) {
+func (s *Server) newTLSListenerEmbed(ln net.Listener, certData, keyData []byte) (net.Listener, error) {
+	if len(certData) == 0 && len(keyData) == 0 {
+		return s.newCertListener(ln, nil)
+	}
 	cert, err := tls.X509KeyPair(certData, keyData)
 	if err != nil {
 		return nil, fmt.Errorf("cannot load TLS key pair from the provided certData(%d) and keyData(%d): %s",
 			len(certData), len(keyData), err)
 	}
-	return newCertListener(ln, &cert), nil
+	return s.newCertListener(ln, &cert)
+}
+
+// 以TLSConfig的副本为基础，追加证书cert(可为nil)
+func (s *Server) newCertListener(ln net.Listener, cert *tls.Certificate) (net.Listener, error) {
+	var tlsConfig *tls.Config
+	if s.TLSConfig != nil {
+		tlsConfig = s.TLSConfig.Clone()
+	} else {
+		tlsConfig = newDefaultTLSConfig()
+	}
+	// Clone与TLSConfig共用Certificates的底层数组,追加前须复制
+	certs := tlsConfig.Certificates[:len(tlsConfig.Certificates):len(tlsConfig.Certificates)]
+	s.certsMu.Lock()
+	certs = append(certs, s.certs...)
+	s.certsMu.Unlock()
+	if cert != nil {
+		certs = append(certs, *cert)
+	}
+	tlsConfig.Certificates = certs
+	if s.ClientCAs != nil {
+		tlsConfig.ClientCAs = s.ClientCAs
+	}
+	if s.ClientAuth != tls.NoClientCert {
+		tlsConfig.ClientAuth = s.ClientAuth
+	} else if s.ClientCAs != nil && tlsConfig.ClientAuth == tls.NoClientCert {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	if len(tlsConfig.Certificates) == 0 && tlsConfig.GetCertificate == nil && tlsConfig.GetConfigForClient == nil {
+		return nil, errNoCertificates
+	}
+	if s.TLSPlaintext != TLSPlaintextOff {
+		return newTLSSniffListener(ln, tlsConfig, s.TLSSniffTimeout), nil
+	}
+	return tls.NewListener(ln, tlsConfig), nil
 }
-func newCertListener(ln net.Listener, cert *tls.Certificate) net.Listener {
-	tlsConfig := &tls.Config{
-		Certificates:             []tls.Certificate{*cert},
+
+func newDefaultTLSConfig() *tls.Config {
+	return &tls.Config{
 		PreferServerCipherSuites: true, // 设为true,Server按顺序使用Certificates里证书
 	}
-	return tls.NewListener(ln, tlsConfig)
 }
 
+// 追加证书,可多次调用,用于同一端口服务多个域名
+// tls握手时，按SNI选择证书;无匹配时，使用第1个证书
+// 证书由Server保存,排在TLSConfig.Certificates之后,不修改TLSConfig
+// 须在ServeTLS*等调用前设置
+func (s *Server) AppendCert(certFile, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("cannot load TLS key pair from certFile=%q and keyFile=%q: %s", certFile, keyFile, err)
+	}
+	return s.appendCert(cert)
+}
+
+// 同AppendCert,证书、私钥为pem数据
+func (s *Server) AppendCertEmbed(certData, keyData []byte) error {
+	cert, err := tls.X509KeyPair(certData, keyData)
+	if err != nil {
+		return fmt.Errorf("cannot load TLS key pair from the provided certData(%d) and keyData(%d): %s",
+			len(certData), len(keyData), err)
+	}
+	return s.appendCert(cert)
+}
+
+// 预先解析Leaf,避免每次握手按SNI匹配时重复解析
+func (s *Server) appendCert(cert tls.Certificate) error {
+	if cert.Leaf == nil {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return fmt.Errorf("cannot parse TLS certificate: %s", err)
+		}
+		cert.Leaf = leaf
+	}
+	s.certsMu.Lock()
+	s.certs = append(s.certs, cert)
+	s.certsMu.Unlock()
+	return nil
+}
+
+var errNoCertificates = errors.New("no TLS certificates: set certFile and keyFile, or Server.TLSConfig")
+
 // 一个server并发数
 const DefaultConcurrency = 256 * 1024 //略少
 
 func (s *Server) Serve(ln net.Listener) error {
+	return s.serve(s.newProxyProtocolListener(ln))
+}
+
+// ln已按ProxyProtocol封装
+// tls监听器中，PROXY protocol头在tls握手前，须封装在tls之下
+func (s *Server) serve(ln net.Listener) error {
 	var lastOverflowErrorTime time.Time // 用于记录上次满载时间，以便计算两次出错间隔，若超过1分钟，打印日志
 	var lastPerIPErrorTime time.Time
 	var c net.Conn
 	var err error
 
 	maxWorkersCount := s.getConcurrency()
-	s.concurrencyCh = make(chan struct{}, maxWorkersCount)
 	wp := &workerPool{
 		ServeFunc:       s.serveConn,
 		MaxWorkersCount: maxWorkersCount,
 		LogAllErrors:    s.LogAllErrors,
 		Logger:          s.logger(),
 	}
+
+	// 登记监听器,以便Shutdown关闭之
+	s.mu.Lock()
+	if atomic.LoadInt32(&s.stop) == 1 {
+		s.mu.Unlock()
+		ln.Close()
+		return nil
+	}
+	s.ln = append(s.ln, ln)
+	if s.concurrencyCh == nil { // 多个Serve共用,见TimeoutHandler
+		s.concurrencyCh = make(chan struct{}, maxWorkersCount)
+	}
+	s.mu.Unlock()
+
+	s.registerWorkerPool(wp)
+	defer s.unregisterWorkerPool(wp)
 	wp.Start()
 
 	for {
 		if c, err = acceptConn(s, ln, &lastPerIPErrorTime); err != nil {
 			wp.Stop() // 出现错误，停止服务
-			if err == io.EOF {
+			if err == io.EOF || atomic.LoadInt32(&s.stop) == 1 {
 				return nil
 			}
 			return err
 		}
-		if !wp.Serve(c) {
-			s.writeFastError(c, StatusServiceUnavailable,
-				"The connection cannot be served because Server.Concurrency limit exceeded")
-			c.Close()
+		atomic.AddInt32(&s.open, 1)
+		if err = s.serveOrQueue(wp, c); err != nil {
+			atomic.AddInt32(&s.open, -1)
+			s.rejectOverloaded(c, err)
+			s.setState(c, StateClosed)
 			if time.Since(lastOverflowErrorTime) > time.Minute { // 超过1分钟还是满载情况，打印日志
-				s.logger().Printf("The incoming connection cannot be served, because %d concurrent connections are served. "+
-					"Try increasing Server.Concurrency", maxWorkersCount)
+				if err == ErrMaxConnsLimit {
+					s.logger().Printf("The incoming connection cannot be served, because %d connections are served. "+
+						"Try increasing Server.MaxConns", s.MaxConns)
+				} else {
+					s.logger().Printf("The incoming connection cannot be served, because %d concurrent connections are served. "+
+						"Try increasing Server.Concurrency", maxWorkersCount)
+				}
 				lastOverflowErrorTime = time.Now()
 			}
-
-			// 当前服务器已满载，给其它服务器机会
-			// 希望其它服务器没有满载
-			time.Sleep(100 * time.Millisecond)
 		}
 		c = nil
 	}
 }
 
+// 将c交给服务池
+// 满载时，按OverloadPolicy:OverloadQueue模式将c排队,在单独的协程中等待空闲服务协程,不阻塞accept;其它模式直接返回
+// 排队的连接数已达Concurrency时，不再排队
+// 无法服务时，返回ErrMaxConnsLimit 或 ErrConcurrencyLimit
+func (s *Server) serveOrQueue(wp *workerPool, c net.Conn) error {
+	err := s.tryServe(wp, c)
+	if err == nil || s.OverloadPolicy != OverloadQueue {
+		return err
+	}
+	if int(atomic.AddInt32(&s.queuedConns, 1)) > s.getConcurrency() {
+		atomic.AddInt32(&s.queuedConns, -1)
+		return err
+	}
+	go s.queueConn(wp, c, err)
+	return nil
+}
+
+// 排队等待空闲服务协程,最多等待OverloadQueueTimeout;超时后拒绝c
+// err:排队前tryServe返回的错误
+func (s *Server) queueConn(wp *workerPool, c net.Conn, err error) {
+	t := acquireTimer(s.getOverloadQueueTimeout())
+	for wp.waitFree(t) {
+		atomic.AddInt32(&s.queuedConns, -1) // 出队后再检测MaxConns
+		if err = s.tryServe(wp, c); err == nil {
+			break
+		}
+		atomic.AddInt32(&s.queuedConns, 1)
+	}
+	releaseTimer(t)
+
+	if err == nil {
+		wp.notifyFree() // 可能还有空闲服务协程,唤醒下一个排队的连接
+		return
+	}
+	atomic.AddInt32(&s.queuedConns, -1)
+	atomic.AddInt32(&s.open, -1)
+	s.rejectOverloaded(c, err)
+	s.setState(c, StateClosed)
+}
+
+func (s *Server) tryServe(wp *workerPool, c net.Conn) error {
+	// open已包含c,不含排队中的连接
+	if s.MaxConns > 0 && int(atomic.LoadInt32(&s.open)-atomic.LoadInt32(&s.queuedConns)) > s.MaxConns {
+		return ErrMaxConnsLimit
+	}
+	if !wp.Serve(c) {
+		return ErrConcurrencyLimit
+	}
+	return nil
+}
+
+// 满载时，按OverloadPolicy拒绝c，并关闭之
+// err为ErrMaxConnsLimit 或 ErrConcurrencyLimit
+func (s *Server) rejectOverloaded(c net.Conn, err error) {
+	if err == ErrMaxConnsLimit {
+		atomic.AddUint64(&s.rejectedMaxConns, 1)
+	} else {
+		atomic.AddUint64(&s.rejectedConcurrency, 1)
+	}
+	if s.OverloadPolicy != OverloadClose {
+		statusCode := s.OverloadStatusCode
+		if statusCode <= 0 {
+			statusCode = StatusServiceUnavailable
+		}
+		msg := s.OverloadMessage
+		if len(msg) == 0 {
+			if err == ErrMaxConnsLimit {
+				msg = "The connection cannot be served because Server.MaxConns limit exceeded"
+			} else {
+				msg = "The connection cannot be served because Server.Concurrency limit exceeded"
+			}
+		}
+		s.writeFastError(c, statusCode, msg)
+	}
+	c.Close()
+}
+
+const defaultOverloadQueueTimeout = 100 * time.Millisecond
+
+func (s *Server) getOverloadQueueTimeout() time.Duration {
+	if s.OverloadQueueTimeout <= 0 {
+		return defaultOverloadQueueTimeout
+	}
+	return s.OverloadQueueTimeout
+}
+
 // 阻塞获取连接
 func acceptConn(s *Server, ln net.Listener, lastPerIPErrorTime *time.Time) (net.Conn, error) {
 	for {
@@ -159,12 +432,13 @@ func acceptConn(s *Server, ln net.Listener, lastPerIPErrorTime *time.Time) (net.
 		if c == nil {
 			panic("BUG: net.Listener returned (nil, nil)")
 		}
+		s.tuneConn(c)
 		if s.MaxConnsPerIP > 0 {
 			pic := wrapPerIPConn(s, c)
 			if pic == nil {
 				if time.Since(*lastPerIPErrorTime) > time.Minute {
 					s.logger().Printf("The number of connections from %s exceeds MaxConnsPerIP=%d",
-						getConnIP4(c), s.MaxConnsPerIP)
+						s.perIPConnKey(c), s.MaxConnsPerIP)
 					*lastPerIPErrorTime = time.Now()
 				}
 				continue
@@ -172,24 +446,30 @@ func acceptConn(s *Server, ln net.Listener, lastPerIPErrorTime *time.Time) (net.
 			c = pic
 		}
 		//		s.logger().Printf("[Accept] new conn:%d", c)
+		s.setState(c, StateNew)
 		return c, nil
 	}
 }
 
-// 检测该ip上的连接数是否超了
+// 检测该ip(前缀)上的连接数是否超了
+// 非ip连接 或 在MaxConnsPerIPExempt中的，不受限制
 func wrapPerIPConn(s *Server, c net.Conn) net.Conn {
-	ip := getUint32IP(c)
-	if ip == 0 {
+	if s.isPerIPExempt(getConnIP(c)) { // 按客户端ip,而非其前缀
+		return c
+	}
+	ip := s.perIPConnKey(c)
+	if !ip.IsValid() {
 		return c
 	}
 	n := s.perIPConnCounter.Register(ip)
 	if n > s.MaxConnsPerIP {
 		s.perIPConnCounter.Unregister(ip)
+		atomic.AddUint64(&s.rejectedPerIP, 1)
 		s.writeFastError(c, StatusTooManyRequests, "The number of connections from your ip exceeds MaxConnsPerIP")
 		c.Close()
 		return nil
 	}
-	return acquirePerIPConn(c, ip, &s.perIPConnCounter)
+	return newPerIPConn(c, ip, &s.perIPConnCounter)
 }
 
 var defaultLogger = Logger(log.New(os.Stderr, "", log.LstdFlags))
@@ -205,6 +485,7 @@ func (s *Server) logger() Logger {
 var (
 	ErrPerIPConnLimit   = errors.New("too many connections per ip")
 	ErrConcurrencyLimit = errors.New("cannot serve the connection because Server.Concurrency concurrent connections are served")
+	ErrMaxConnsLimit    = errors.New("cannot serve the connection because Server.MaxConns connections are served")
 	ErrKeepaliveTimeout = errors.New("exceeded MaxKeepaliveDuration")
 )
 
@@ -224,11 +505,17 @@ func (s *Server) ServeConn(c net.Conn) error {
 	n := atomic.AddUint32(&s.concurrency, 1)
 	if n > uint32(s.getConcurrency()) {
 		atomic.AddUint32(&s.concurrency, ^uint32(0)) // -1
-		s.writeFastError(c, StatusServiceUnavailable, "The connection cannot be served because Server.Concurrency limit exceeded")
-		c.Close()
+		s.rejectOverloaded(c, ErrConcurrencyLimit)
 		return ErrConcurrencyLimit
 	}
 
+	if open := atomic.AddInt32(&s.open, 1); s.MaxConns > 0 && int(open) > s.MaxConns {
+		atomic.AddInt32(&s.open, -1)
+		atomic.AddUint32(&s.concurrency, ^uint32(0)) // -1
+		s.rejectOverloaded(c, ErrMaxConnsLimit)
+		return ErrMaxConnsLimit
+	}
+	s.setState(c, StateNew)
 	err := s.serveConn(c)
 
 	atomic.AddUint32(&s.concurrency, ^uint32(0)) // -1
@@ -270,6 +557,8 @@ const DefaultMaxRequestBodySize = 4 * 1024 * 1024 // 4M
 // 具体http服务处理
 // 没有引用c即可
 func (s *Server) serveConn(c net.Conn) error {
+	defer atomic.AddInt32(&s.open, -1) // 连接计数在Serve/ServeConn中递增
+
 	serverName := s.getServerName()
 	connRequestNum := uint64(0)
 	connID := nextConnID()
@@ -280,9 +569,11 @@ func (s *Server) serveConn(c net.Conn) error {
 		maxRequestBodySize = DefaultMaxRequestBodySize
 	}
 
-	ctx := s.acquireCtx(c) // 产生RequestCtx
+	tc := s.trackedConn(c)
+	ctx := s.acquireCtx(c, tc) // 产生RequestCtx
 	ctx.connTime = connTime
 	isTLS := ctx.IsTLS()
+	redirectHTTPS := s.TLSPlaintext == TLSPlaintextRedirect && isPlaintextOnTLS(c)
 	var (
 		br *bufio.Reader // 读-获取请求数据
 		bw *bufio.Writer // 写-响应数据
@@ -296,6 +587,8 @@ func (s *Server) serveConn(c net.Conn) error {
 
 		connectionClose bool
 		isHTTP11        bool
+
+		rateKeyBuf []byte // 限速key缓冲区
 	)
 	//	s.logger().Printf("[Server] serveConn:%d c:%d", connID, c)
 	// 工作原理:
@@ -307,7 +600,16 @@ func (s *Server) serveConn(c net.Conn) error {
 		ctx.time = currentTime
 		//		ctx.Logger().Printf("connRequestNum:%d", connRequestNum)
 
-		if s.ReadTimeout > 0 || s.MaxKeepaliveDuration > 0 {
+		// 长连接等待下一请求:设置了IdleTimeout，则等待期间使用闲置超时,读到首字节后，再改为读超时
+		// 设置了HeaderReadTimeout，同样需等到首字节，再开始计时
+		waitIdle := (s.IdleTimeout > 0 || s.HeaderReadTimeout > 0) && connRequestNum > 1 && br == nil
+		if waitIdle {
+			if !s.updateIdleDeadline(c, ctx) {
+				err = ErrKeepaliveTimeout
+				break
+			}
+			lastReadDeadlineTime = zeroTime // 读取请求时，须重设读超时
+		} else if s.ReadTimeout > 0 || s.MaxKeepaliveDuration > 0 {
 			lastReadDeadlineTime = s.updateReadDeadline(c, ctx, lastReadDeadlineTime) // 更新读超时
 			if lastReadDeadlineTime.IsZero() {
 				err = ErrKeepaliveTimeout
@@ -315,9 +617,33 @@ func (s *Server) serveConn(c net.Conn) error {
 			}
 		}
 
+		// 等待请求期间，为闲置连接，Shutdown可直接关闭之
+		// 首个请求前，连接已处于StateNew
+		if br == nil || br.Buffered() == 0 {
+			if atomic.LoadInt32(&s.stop) == 1 {
+				break
+			}
+			if connRequestNum > 1 {
+				s.setConnState(tc, StateIdle)
+			}
+		}
+
 		// 读取器初始化：非‘最小内存模式’ 读取间隔<=1秒(在同连接上的后1请求)
 		// 确认为该连接第2之后请求，使用首字节探测器-阻塞等待后续请求
-		if !(s.ReduceMemoryUsage || ctx.lastReadDuration > time.Second) || br != nil {
+		if waitIdle { // 闲置等待 -- 使用首字节探测器，读到首字节后，改为读超时
+			br, err = acquireByteReader(&ctx)
+			if err == nil {
+				ctx.time = time.Now()
+				if s.ReadTimeout > 0 || s.MaxKeepaliveDuration > 0 {
+					lastReadDeadlineTime = s.updateReadDeadline(c, ctx, lastReadDeadlineTime)
+					if lastReadDeadlineTime.IsZero() {
+						err = ErrKeepaliveTimeout
+					}
+				} else if err = c.SetReadDeadline(zeroTime); err != nil {
+					panic(fmt.Sprintf("BUG: error in SetReadDeadline(zeroTime): %s", err))
+				}
+			}
+		} else if !(s.ReduceMemoryUsage || ctx.lastReadDuration > time.Second) || br != nil {
 			//			ctx.Logger().Printf("acquireReader")
 			if br == nil {
 				br = acquireReader(ctx)
@@ -337,7 +663,13 @@ func (s *Server) serveConn(c net.Conn) error {
 				ctx.Response.Header.DisableNormalizing()
 			}
 			// 读取body
-			err = ctx.Request.readLimitBody(br, maxRequestBodySize, s.GetPostOnly)
+			if s.HeaderReadTimeout > 0 || s.MinRequestBodyRate > 0 {
+				// 头部、body分阶段读取，各自超时
+				err = s.readRequestTimed(ctx, tc, br, maxRequestBodySize)
+				lastReadDeadlineTime = zeroTime // 读超时已被改动，下一请求须重设
+			} else if err = s.waitRequestStart(tc, br, s.requestReadDeadline(ctx)); err == nil {
+				err = ctx.Request.readLimitBody(br, maxRequestBodySize, s.GetPostOnly)
+			}
 			if br.Buffered() == 0 || err != nil { // 读取完成/出错(停止当前连接处理)
 				//				ctx.Logger().Printf("releaseReader")
 				releaseReader(s, br)
@@ -350,10 +682,10 @@ func (s *Server) serveConn(c net.Conn) error {
 		//		ctx.Logger().Printf("stop:%q", currentTime.String())
 
 		if err != nil { // 申请读取器出错 || 首次读取出错
-			if err == io.EOF { // 读取到末尾，请求结束
+			if err == io.EOF || atomic.LoadInt32(&s.stop) == 1 { // 读取到末尾 或 被Shutdown关闭，请求结束
 				err = nil
 			} else { // 响应错误信息
-				bw = writeErrorResponse(bw, ctx, err)
+				bw = s.writeErrorResponse(bw, ctx, err)
 			}
 			break
 		}
@@ -377,18 +709,27 @@ func (s *Server) serveConn(c net.Conn) error {
 			if br == nil {
 				br = acquireReader(ctx)
 			}
-			err = ctx.Request.ContinueReadBody(br, maxRequestBodySize)
+			if s.MinRequestBodyRate > 0 {
+				readDeadline := s.requestReadDeadline(ctx)
+				ctx.mrr.startMinRate(s.MinRequestBodyRate, readDeadline)
+				err = ctx.Request.ContinueReadBody(br, maxRequestBodySize)
+				ctx.mrr.stopMinRate()
+				c.SetReadDeadline(readDeadline)
+				lastReadDeadlineTime = zeroTime
+			} else {
+				err = ctx.Request.ContinueReadBody(br, maxRequestBodySize)
+			}
 			if br.Buffered() == 0 || err != nil { // 未读取到内容或出错
 				releaseReader(s, br)
 				br = nil
 			}
 			if err != nil {
-				bw = writeErrorResponse(bw, ctx, err)
+				bw = s.writeErrorResponse(bw, ctx, err)
 				break
 			}
 		}
 
-		connectionClose = s.DisableKeepalive || ctx.Request.Header.connectionCloseFast()
+		connectionClose = s.DisableKeepalive || ctx.Request.Header.connectionCloseFast() || atomic.LoadInt32(&s.stop) == 1
 		isHTTP11 = ctx.Request.Header.IsHTTP11()
 
 		// 初始化ctx
@@ -397,12 +738,17 @@ func (s *Server) serveConn(c net.Conn) error {
 		ctx.connRequestNum = connRequestNum
 		ctx.connTime = connTime
 		ctx.time = currentTime
-		s.Handler(ctx) // 调用用户设置的处理请求接口
+		if redirectHTTPS { // tls端口上的明文请求
+			s.redirectToHTTPS(ctx)
+		} else if s.RequestRateLimit <= 0 || s.allowRequest(ctx, &rateKeyBuf) { // 超出限速时，已设置429响应
+			s.callHandler(ctx) // 调用用户设置的处理请求接口
+		}
+		atomic.AddUint64(&s.requestsServed, 1)
 
 		// 超时处理 - 若有超时响应，直接使用超时响应
 		timeoutResponse = ctx.timeoutResponse
 		if timeoutResponse != nil {
-			ctx = s.acquireCtx(c) // todo?? leak ctx => gc
+			ctx = s.acquireCtx(c, tc) // todo?? leak ctx => gc
 			timeoutResponse.CopyTo(&ctx.Response)
 			if br != nil {
 				// 因br有可能是与旧ctx.fbr关联，关闭连接
@@ -433,7 +779,9 @@ func (s *Server) serveConn(c net.Conn) error {
 
 		// 长连接处理
 		// 因RequestHandler可能触发 header的解析，此处再次确认请求里的connectionClose
-		connectionClose = connectionClose || ctx.Request.Header.connectionCloseFast() || ctx.Response.ConnectionClose()
+		// 处理期间调用了Shutdown，也须关闭连接
+		connectionClose = connectionClose || ctx.Request.Header.connectionCloseFast() || ctx.Response.ConnectionClose() ||
+			atomic.LoadInt32(&s.stop) == 1
 		if connectionClose {
 			ctx.Response.Header.SetCanonical(strConnection, strClose)
 		} else if !isHTTP11 {
@@ -478,7 +826,7 @@ func (s *Server) serveConn(c net.Conn) error {
 				br = nil
 
 				// br有可能引用ctx.fbr,不能将ctx还给池 todo?? leak ctx => gc
-				ctx = s.acquireCtx(c)
+				ctx = s.acquireCtx(c, tc)
 			}
 			// 清空并释放bw
 			if bw != nil {
@@ -491,6 +839,8 @@ func (s *Server) serveConn(c net.Conn) error {
 			}
 			c.SetReadDeadline(zeroTime)  // 劫持处理，不超时
 			c.SetWriteDeadline(zeroTime) // 劫持处理，不超时
+			s.setState(c, StateHijacked)
+			atomic.AddUint64(&s.hijackedConns, 1)
 			go hijackConnHandler(hjr, c, s, hijackHandler)
 			hijackHandler = nil
 			err = errHijacked
@@ -507,11 +857,236 @@ func (s *Server) serveConn(c net.Conn) error {
 		releaseWriter(s, bw)
 	}
 	s.releaseCtx(ctx) // 释放ctx
+	s.flushConnStats(tc)
+
+	if err != errHijacked {
+		// 连接随后由调用者关闭
+		s.setState(c, StateClosed)
+	}
 
 	//	s.logger().Printf("[Server] serveConn over:%d c:%d", connID, c)
 	return err
 }
 
+// 调用Handler
+// 恢复其中的panic:交由handlePanic处理，工作协程继续服务其它连接
+func (s *Server) callHandler(ctx *RequestCtx) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.handlePanic(ctx, r)
+		}
+	}()
+	s.Handler(ctx)
+}
+
+// 处理Handler中的panic
+// 须在recover的defer函数中调用，PanicHandler中debug.Stack()才包含panic的调用栈
+// 放弃已生成的响应及劫持，响应由PanicHandler设置，默认为StatusInternalServerError
+// 处理后，关闭连接
+func (s *Server) handlePanic(ctx *RequestCtx, r interface{}) {
+	atomic.AddUint64(&s.panics, 1)
+	ctx.hijackHandler = nil
+	ctx.Response.Reset()
+	if s.PanicHandler != nil {
+		s.PanicHandler(ctx, r)
+	} else {
+		s.logger().Printf("panic when serving %q<->%q: %v\n%s", ctx.LocalAddr(), ctx.RemoteAddr(), r, debug.Stack())
+		ctx.Error(StatusMessage(StatusInternalServerError), StatusInternalServerError)
+	}
+	ctx.SetConnectionClose()
+}
+
+// 调用劫持处理接口
+// 响应已发送，panic时仅记录日志，随后关闭连接
+func (s *Server) callHijackHandler(h HijackHandler, c net.Conn) {
+	defer func() {
+		if r := recover(); r != nil {
+			atomic.AddUint64(&s.panics, 1)
+			s.logger().Printf("panic in hijack handler %q<->%q: %v\n%s", c.LocalAddr(), c.RemoteAddr(), r, debug.Stack())
+		}
+	}()
+	h(c)
+}
+
+// 处理RequestCtx.SetBodyStreamWriter中的panic
+// 响应头已发送，仅记录日志;流随即返回错误，连接被关闭
+func (s *Server) handleStreamPanic(r interface{}) {
+	atomic.AddUint64(&s.panics, 1)
+	s.logger().Printf("panic in StreamWriter: %v\n%s", r, debug.Stack())
+}
+
+// 服务中的连接及其状态,见Server.conns
+type trackedConn struct {
+	// 该连接的读写字节数,见statsConnIO;serveConn返回时汇总到Server
+	// 置于首位以保证32位平台上原子操作的64位对齐
+	bytesRead    uint64
+	bytesWritten uint64
+
+	c     net.Conn
+	state int32 // ConnState,由服务该连接的协程原子更新
+
+	// Shutdown中断闲置连接时持有;与连接转为StateActive后恢复读超时互斥,
+	// 以免已开始读取的请求被中断
+	mu sync.Mutex
+}
+
+// 更新连接状态:StateNew时登记到conns,StateHijacked、StateClosed时移出
+// 每个请求的StateActive、StateIdle用setConnState,不访问conns
+func (s *Server) setState(c net.Conn, state ConnState) {
+	switch state {
+	case StateNew:
+		s.connsMu.Lock()
+		if s.conns == nil {
+			s.conns = make(map[net.Conn]*trackedConn)
+		}
+		s.conns[c] = &trackedConn{c: c}
+		s.connsMu.Unlock()
+	case StateHijacked, StateClosed:
+		s.connsMu.Lock()
+		delete(s.conns, c)
+		s.connsMu.Unlock()
+	}
+
+	if hook := s.ConnState; hook != nil {
+		hook(c, state)
+	}
+}
+
+// 返回setState(c, StateNew)登记的连接
+// serveConn开始时调用一次
+func (s *Server) trackedConn(c net.Conn) *trackedConn {
+	s.connsMu.Lock()
+	tc := s.conns[c]
+	s.connsMu.Unlock()
+	if tc == nil { // 未登记,Shutdown不会中断之
+		tc = &trackedConn{c: c}
+	}
+	return tc
+}
+
+// 更新连接状态:StateActive 或 StateIdle
+// 仅原子更新tc.state,不加锁
+func (s *Server) setConnState(tc *trackedConn, state ConnState) {
+	atomic.StoreInt32(&tc.state, int32(state))
+	if hook := s.ConnState; hook != nil {
+		hook(tc.c, state)
+	}
+}
+
+// 关闭所有闲置连接(StateNew,StateIdle)
+// 仅将读超时设为当前时间，阻塞在读请求的serveConn随即返回，由其所有者关闭连接
+// 不直接Close,避免与所有者重复关闭(如perIPConn)
+// Shutdown循环调用,以免读超时被serveConn覆盖
+func (s *Server) closeIdleConns() {
+	now := time.Now()
+	s.connsMu.Lock()
+	for _, tc := range s.conns {
+		tc.mu.Lock()
+		if state := ConnState(atomic.LoadInt32(&tc.state)); state == StateNew || state == StateIdle {
+			tc.c.SetReadDeadline(now)
+		}
+		tc.mu.Unlock()
+	}
+	s.connsMu.Unlock()
+}
+
+// 更新闲置超时时间:长连接等待下一请求
+// 未设置IdleTimeout，使用ReadTimeout;都未设置，则不超时
+// 长连接已超过MaxKeepaliveDuration，返回false
+func (s *Server) updateIdleDeadline(c net.Conn, ctx *RequestCtx) bool {
+	idleTimeout := s.IdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = s.ReadTimeout
+	}
+	currentTime := ctx.time
+	if s.MaxKeepaliveDuration > 0 {
+		connTimeout := s.MaxKeepaliveDuration - currentTime.Sub(ctx.connTime)
+		if connTimeout <= 0 { // 连接超时,通告上层接口
+			return false
+		}
+		if idleTimeout <= 0 || connTimeout < idleTimeout { // 闲置超时 <= 连接超时
+			idleTimeout = connTimeout
+		}
+	}
+	deadline := zeroTime
+	if idleTimeout > 0 {
+		deadline = currentTime.Add(idleTimeout)
+	}
+	if err := c.SetReadDeadline(deadline); err != nil {
+		panic(fmt.Sprintf("BUG: error in SetReadDeadline(%s): %s", idleTimeout, err))
+	}
+	return true
+}
+
+// 返回读取当前请求的最后期限:受ReadTimeout、MaxKeepaliveDuration限制
+// 都未设置，返回zeroTime
+func (s *Server) requestReadDeadline(ctx *RequestCtx) time.Time {
+	deadline := zeroTime
+	if s.ReadTimeout > 0 {
+		deadline = ctx.time.Add(s.ReadTimeout)
+	}
+	if s.MaxKeepaliveDuration > 0 {
+		connDeadline := ctx.connTime.Add(s.MaxKeepaliveDuration)
+		if deadline.IsZero() || connDeadline.Before(deadline) {
+			deadline = connDeadline
+		}
+	}
+	return deadline
+}
+
+// 等待请求首字节,到达后连接转为StateActive,不再是闲置连接
+// 此后Shutdown不再中断该请求的读取
+// 首字节读取失败，都当作io.EOF处理(同RequestHeader.tryRead)
+// deadline:当前的读超时,Shutdown可能已在首字节到达后将其改为当前时间,此时恢复之
+func (s *Server) waitRequestStart(tc *trackedConn, br *bufio.Reader, deadline time.Time) error {
+	if _, err := br.Peek(1); err != nil {
+		return io.EOF
+	}
+	s.setConnState(tc, StateActive)
+	if atomic.LoadInt32(&s.stop) == 1 {
+		tc.mu.Lock() // 等待正在进行的closeIdleConns
+		tc.c.SetReadDeadline(deadline)
+		tc.mu.Unlock()
+	}
+	return nil
+}
+
+// 分阶段读取请求
+// 头部: 受HeaderReadTimeout限制
+// body: 受MinRequestBodyRate限制
+// 两者都不超过ReadTimeout;返回前，读超时恢复为ReadTimeout
+func (s *Server) readRequestTimed(ctx *RequestCtx, tc *trackedConn, br *bufio.Reader, maxBodySize int) error {
+	c := ctx.c
+	readDeadline := s.requestReadDeadline(ctx)
+	headerDeadline := readDeadline
+	if s.HeaderReadTimeout > 0 {
+		headerDeadline = time.Now().Add(s.HeaderReadTimeout)
+		if !readDeadline.IsZero() && readDeadline.Before(headerDeadline) {
+			headerDeadline = readDeadline
+		}
+		c.SetReadDeadline(headerDeadline)
+	}
+	err := s.waitRequestStart(tc, br, headerDeadline)
+	if err != nil {
+		return err
+	}
+	err = ctx.Request.readHeader(br, s.GetPostOnly)
+	if err == nil {
+		if s.HeaderReadTimeout > 0 {
+			c.SetReadDeadline(readDeadline)
+		}
+		if s.MinRequestBodyRate > 0 {
+			ctx.mrr.startMinRate(s.MinRequestBodyRate, readDeadline)
+			err = ctx.Request.readLimitBodyAfterHeader(br, maxBodySize)
+			ctx.mrr.stopMinRate()
+			c.SetReadDeadline(readDeadline)
+		} else {
+			err = ctx.Request.readLimitBodyAfterHeader(br, maxBodySize)
+		}
+	}
+	return err
+}
+
 // 更新读超时时间
 func (s *Server) updateReadDeadline(c net.Conn, ctx *RequestCtx, lastDeadlineTime time.Time) time.Time {
 	readTimeout := s.ReadTimeout
@@ -521,7 +1096,7 @@ func (s *Server) updateReadDeadline(c net.Conn, ctx *RequestCtx, lastDeadlineTim
 		if connTimeout <= 0 { // 连接超时,通告上层接口
 			return zeroTime
 		}
-		if connTimeout < readTimeout { // 读超时 <= 连接超时
+		if readTimeout <= 0 || connTimeout < readTimeout { // 读超时 <= 连接超时
 			readTimeout = connTimeout
 		}
 	}
@@ -547,7 +1122,7 @@ func (s *Server) updateWriteDeadline(c net.Conn, ctx *RequestCtx, lastDeadlineTi
 			ctx.SetConnectionClose()
 			connTimeout = 100 * time.Millisecond
 		}
-		if connTimeout < writeTimeout { // 写超时应<=连接超时
+		if writeTimeout <= 0 || connTimeout < writeTimeout { // 写超时应<=连接超时
 			writeTimeout = connTimeout
 		}
 	}
@@ -568,7 +1143,7 @@ func (s *Server) updateWriteDeadline(c net.Conn, ctx *RequestCtx, lastDeadlineTi
 // serveConn调用
 func hijackConnHandler(r io.Reader, c net.Conn, s *Server, h HijackHandler) {
 	hjc := s.acquireHijackConn(r, c)
-	h(hjc)
+	s.callHijackHandler(h, hjc)
 
 	if br, ok := r.(*bufio.Reader); ok {
 		releaseReader(s, br)
@@ -637,6 +1212,7 @@ func acquireByteReader(ctxP **RequestCtx) (*bufio.Reader, error) {
 	ctx := *ctxP
 	s := ctx.s
 	c := ctx.c
+	tc := ctx.cio.tc
 	t := ctx.time
 	s.releaseCtx(ctx) // 仅需置空c
 
@@ -652,7 +1228,10 @@ func acquireByteReader(ctxP **RequestCtx) (*bufio.Reader, error) {
 	n, err := c.Read(b) // 监听首字节
 	ch := b[0]
 	s.bytePool.Put(v)
-	ctx = s.acquireCtx(c)
+	if n > 0 {
+		atomic.AddUint64(&tc.bytesRead, uint64(n))
+	}
+	ctx = s.acquireCtx(c, tc)
 
 	ctx.time = t
 	*ctxP = ctx
@@ -664,7 +1243,7 @@ func acquireByteReader(ctxP **RequestCtx) (*bufio.Reader, error) {
 		panic("BUG: Reader must return at least one byte")
 	}
 
-	ctx.fbr.c = c
+	ctx.fbr.c = &ctx.cio
 	ctx.fbr.ch = ch          // 首字节
 	ctx.fbr.byteRead = false // 还未把首字节使用掉
 	r := acquireReader(ctx)
@@ -680,10 +1259,10 @@ func acquireReader(ctx *RequestCtx) *bufio.Reader {
 		if n <= 0 {
 			n = defaultReadBufferSize
 		}
-		return bufio.NewReaderSize(ctx.c, n)
+		return bufio.NewReaderSize(&ctx.cio, n)
 	}
 	r := v.(*bufio.Reader)
-	r.Reset(ctx.c)
+	r.Reset(&ctx.cio)
 	return r
 }
 func releaseReader(s *Server, r *bufio.Reader) {
@@ -701,10 +1280,10 @@ func acquireWriter(ctx *RequestCtx) *bufio.Writer {
 		if n <= 0 {
 			n = defaultWriteBufferSize
 		}
-		return bufio.NewWriterSize(ctx.c, n)
+		return bufio.NewWriterSize(&ctx.cio, n)
 	}
 	w := v.(*bufio.Writer)
-	w.Reset(ctx.c)
+	w.Reset(&ctx.cio)
 	//	ctx.Logger().Printf("acquireWriter:%x, %+v, %x", ctx.c, w, GetAddr(w))
 	return w
 }
@@ -715,7 +1294,7 @@ func releaseWriter(s *Server, w *bufio.Writer) {
 	s.writerPool.Put(w)
 }
 
-func (s *Server) acquireCtx(c net.Conn) *RequestCtx {
+func (s *Server) acquireCtx(c net.Conn, tc *trackedConn) *RequestCtx {
 	v := s.ctxPool.Get()
 	var ctx *RequestCtx
 	if v == nil {
@@ -735,6 +1314,10 @@ func (s *Server) acquireCtx(c net.Conn) *RequestCtx {
 		ctx.Response.keepBodyBuffer = keepBodyBuffer
 	}
 	ctx.c = c
+	ctx.mrr.c = c
+	ctx.cio.c = c
+	ctx.cio.r = &ctx.mrr
+	ctx.cio.tc = tc
 	return ctx
 }
 
@@ -808,6 +1391,10 @@ func (s *Server) releaseCtx(ctx *RequestCtx) {
 	}
 	ctx.c = nil
 	ctx.fbr.c = nil
+	ctx.mrr.c = nil
+	ctx.cio.c = nil
+	ctx.cio.r = nil
+	ctx.cio.tc = nil
 	s.ctxPool.Put(ctx)
 }
 
@@ -841,11 +1428,23 @@ func (s *Server) writeFastError(w io.Writer, statusCode int, msg string) {
 
 //  todo??
 // 将错误写入响应，给客户端
-func writeErrorResponse(bw *bufio.Writer, ctx *RequestCtx, err error) *bufio.Writer {
-	if _, ok := err.(*ErrSmallBuffer); ok {
-		ctx.Error("Too big request header", StatusRequestHeaderFieldsTooLarge)
+func (s *Server) writeErrorResponse(bw *bufio.Writer, ctx *RequestCtx, err error) *bufio.Writer {
+	err = requestError(err)
+	if s.ErrorHandler != nil {
+		s.ErrorHandler(ctx, err)
 	} else {
-		ctx.Error("Error when parsing request", StatusBadRequest)
+		switch err.(type) {
+		case *ErrSmallBuffer:
+			ctx.Error("Too big request header", StatusRequestHeaderFieldsTooLarge)
+		case *ErrReadTimeout:
+			ctx.Error("Request timeout", StatusRequestTimeout)
+		default:
+			if err == ErrBodyTooLarge {
+				ctx.Error("Request body too large", StatusRequestEntityTooLarge)
+			} else {
+				ctx.Error("Error when parsing request", StatusBadRequest)
+			}
+		}
 	}
 	ctx.SetConnectionClose()
 	if bw == nil {
@@ -855,3 +1454,19 @@ func writeErrorResponse(bw *bufio.Writer, ctx *RequestCtx, err error) *bufio.Wri
 	bw.Flush()
 	return bw
 }
+
+// 归类读取请求的错误，交给ErrorHandler
+// *ErrSmallBuffer,ErrBodyTooLarge原样返回;网络读超时(含被包装的)归为*ErrReadTimeout;其它归为*ErrBadRequest
+func requestError(err error) error {
+	switch err.(type) {
+	case *ErrSmallBuffer, *ErrReadTimeout, *ErrBadRequest:
+		return err
+	}
+	if err == ErrBodyTooLarge {
+		return err
+	}
+	if isTimeoutError(err) {
+		return &ErrReadTimeout{error: err}
+	}
+	return &ErrBadRequest{error: err}
+}
@@ -0,0 +1,774 @@
+package selfFastHttp
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/forTWOS/selfFastHttp/reuseport"
+	"github.com/forTWOS/selfFastHttp/selffasthttputil"
+)
+
+// 在InmemoryListener上运行s.Serve,返回监听器及Serve的结果
+func startInmemoryServer(t *testing.T, s *Server) (*selffasthttputil.InmemoryListener, <-chan error) {
+	if s.Logger == nil {
+		s.Logger = &testLogger{}
+	}
+	ln := selffasthttputil.NewInmemoryListener()
+	serveCh := make(chan error, 1)
+	go func() { serveCh <- s.Serve(ln) }()
+	t.Cleanup(func() { ln.Close() })
+	return ln, serveCh
+}
+
+func dialInmemory(t *testing.T, ln *selffasthttputil.InmemoryListener) net.Conn {
+	c, err := ln.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// 在c上发送req,读取响应
+func testDoRaw(t *testing.T, c net.Conn, br *bufio.Reader, req string) *Response {
+	if _, err := c.Write([]byte(req)); err != nil {
+		t.Fatal(err)
+	}
+	resp := &Response{}
+	if err := resp.Read(br); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+const testGetRequest = "GET / HTTP/1.1\r\nHost: a\r\n\r\n"
+
+// 等待c被服务端关闭
+func expectConnClosed(t *testing.T, c net.Conn) {
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("unexpected error %v, want %v", err, io.EOF)
+	}
+}
+
+func TestServerShutdownIdleConns(t *testing.T) {
+	s := &Server{Handler: func(ctx *RequestCtx) { ctx.WriteString("ok") }}
+	ln, serveCh := startInmemoryServer(t, s)
+
+	var conns []net.Conn
+	for i := 0; i < 3; i++ { // 闲置的长连接
+		c := dialInmemory(t, ln)
+		if resp := testDoRaw(t, c, bufio.NewReader(c), testGetRequest); string(resp.Body()) != "ok" {
+			t.Fatalf("unexpected body %q", resp.Body())
+		}
+		conns = append(conns, c)
+	}
+	conns = append(conns, dialInmemory(t, ln)) // 未发送请求的新连接
+
+	start := time.Now()
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("Shutdown took too long: %s", d)
+	}
+	if err := <-serveCh; err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for _, c := range conns {
+		expectConnClosed(t, c)
+	}
+	if _, err := ln.Dial(); err == nil {
+		t.Fatalf("expecting error when dialing after Shutdown")
+	}
+	// Shutdown后，Serve直接返回
+	if err := s.Serve(selffasthttputil.NewInmemoryListener()); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestServerShutdownInflight(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s := &Server{Handler: func(ctx *RequestCtx) {
+		close(started)
+		<-release
+		ctx.WriteString("done")
+	}}
+	ln, serveCh := startInmemoryServer(t, s)
+	c := dialInmemory(t, ln)
+	c.Write([]byte(testGetRequest))
+	<-started
+
+	shutdownCh := make(chan error, 1)
+	go func() { shutdownCh <- s.Shutdown(context.Background()) }()
+	select {
+	case err := <-shutdownCh:
+		t.Fatalf("Shutdown returned %v while a request is in flight", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	close(release)
+	var resp Response
+	if err := resp.Read(bufio.NewReader(c)); err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.Body()) != "done" || !resp.ConnectionClose() {
+		t.Fatalf("unexpected response %q, Connection: close=%v", resp.Body(), resp.ConnectionClose())
+	}
+	if err := <-shutdownCh; err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := <-serveCh; err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+// 首字节已到达的请求,Shutdown不中断其读取
+func TestServerShutdownInflightBody(t *testing.T) {
+	for _, s := range []*Server{
+		{},
+		{HeaderReadTimeout: 5 * time.Second},
+		{MinRequestBodyRate: 1},
+	} {
+		s.Handler = func(ctx *RequestCtx) { ctx.Write(ctx.PostBody()) }
+		ln, _ := startInmemoryServer(t, s)
+		c := dialInmemory(t, ln)
+		c.Write([]byte("POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 10\r\n\r\n12345"))
+		time.Sleep(50 * time.Millisecond)
+
+		shutdownCh := make(chan error, 1)
+		go func() { shutdownCh <- s.Shutdown(context.Background()) }()
+		time.Sleep(2 * shutdownPollInterval)
+		c.Write([]byte("67890"))
+
+		var resp Response
+		if err := resp.Read(bufio.NewReader(c)); err != nil {
+			t.Fatal(err)
+		}
+		if string(resp.Body()) != "1234567890" {
+			t.Fatalf("unexpected body %q", resp.Body())
+		}
+		if err := <-shutdownCh; err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+}
+
+func TestServerShutdownContext(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s := &Server{Handler: func(ctx *RequestCtx) {
+		close(started)
+		<-release
+	}}
+	ln, _ := startInmemoryServer(t, s)
+	c := dialInmemory(t, ln)
+	c.Write([]byte(testGetRequest))
+	<-started
+
+	firstCh := make(chan error, 1)
+	go func() { firstCh <- s.Shutdown(context.Background()) }()
+	time.Sleep(50 * time.Millisecond)
+
+	// 并发的Shutdown不等待前一个,在其ctx到期时返回
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	secondCh := make(chan error, 1)
+	go func() { secondCh <- s.Shutdown(ctx) }()
+	select {
+	case err := <-secondCh:
+		if err != context.DeadlineExceeded {
+			t.Errorf("unexpected error %v, want %v", err, context.DeadlineExceeded)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("concurrent Shutdown is blocked")
+	}
+
+	close(release)
+	if err := <-firstCh; err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestServerConnStateOrder(t *testing.T) {
+	statesCh := make(chan ConnState, 16)
+	s := &Server{
+		Handler:   func(ctx *RequestCtx) { ctx.WriteString("ok") },
+		ConnState: func(c net.Conn, state ConnState) { statesCh <- state },
+	}
+	ln, _ := startInmemoryServer(t, s)
+	c := dialInmemory(t, ln)
+	br := bufio.NewReader(c)
+	testDoRaw(t, c, br, testGetRequest)
+	testDoRaw(t, c, br, testGetRequest)
+	c.Close()
+
+	want := []ConnState{StateNew, StateActive, StateIdle, StateActive, StateIdle, StateClosed}
+	for i, w := range want {
+		select {
+		case state := <-statesCh:
+			if state != w {
+				t.Fatalf("unexpected state #%d %s, want %s", i, state, w)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timeout waiting for state #%d %s", i, w)
+		}
+	}
+}
+
+// ConnState中关闭连接:不影响服务协程,per-ip计数正确
+func TestServerConnStateClose(t *testing.T) {
+	for _, closeOn := range []ConnState{StateNew, StateActive, StateIdle} {
+		closedCh := make(chan struct{}, 4)
+		s := &Server{
+			Handler:       func(ctx *RequestCtx) { ctx.WriteString("ok") },
+			MaxConnsPerIP: 1,
+			Logger:        &testLogger{},
+		}
+		s.ConnState = func(c net.Conn, state ConnState) {
+			if state == closeOn {
+				c.Close()
+			}
+			if state == StateClosed {
+				closedCh <- struct{}{}
+			}
+		}
+		ln, err := net.Listen("tcp4", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go s.Serve(ln)
+
+		c, err := net.Dial("tcp4", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		br := bufio.NewReader(c)
+		c.Write([]byte(testGetRequest + testGetRequest))
+		var resp Response
+		for resp.Read(br) == nil {
+		}
+		c.Close()
+		select {
+		case <-closedCh:
+		case <-time.After(time.Second):
+			t.Fatalf("%s: timeout waiting for StateClosed", closeOn)
+		}
+		if m := s.perIPConnCounter.Snapshot(); len(m) != 0 {
+			t.Fatalf("%s: unexpected per-ip counters %v", closeOn, m)
+		}
+		ln.Close()
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += n
+	return n, err
+}
+
+// 读写字节数在连接结束时计入Stats
+func TestServerStatsBytes(t *testing.T) {
+	closedCh := make(chan struct{}, 1)
+	s := &Server{
+		Handler: func(ctx *RequestCtx) { ctx.WriteString("ok") },
+		ConnState: func(c net.Conn, state ConnState) {
+			if state == StateClosed {
+				closedCh <- struct{}{}
+			}
+		},
+	}
+	ln, _ := startInmemoryServer(t, s)
+	c := dialInmemory(t, ln)
+	cr := &countingReader{r: c}
+	br := bufio.NewReader(cr)
+	testDoRaw(t, c, br, testGetRequest)
+	testDoRaw(t, c, br, testGetRequest)
+	c.Close()
+	select {
+	case <-closedCh:
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for StateClosed")
+	}
+
+	st := s.Stats()
+	if want := uint64(2 * len(testGetRequest)); st.BytesRead != want {
+		t.Fatalf("unexpected BytesRead %d, want %d", st.BytesRead, want)
+	}
+	if want := uint64(cr.n); st.BytesWritten != want {
+		t.Fatalf("unexpected BytesWritten %d, want %d", st.BytesWritten, want)
+	}
+}
+
+// 闲置长连接按IdleTimeout关闭
+func TestServerIdleTimeout(t *testing.T) {
+	s := &Server{
+		Handler:     func(ctx *RequestCtx) { ctx.WriteString("ok") },
+		ReadTimeout: 5 * time.Second,
+		IdleTimeout: 100 * time.Millisecond,
+	}
+	ln, _ := startInmemoryServer(t, s)
+	c := dialInmemory(t, ln)
+	testDoRaw(t, c, bufio.NewReader(c), testGetRequest)
+
+	start := time.Now()
+	expectConnClosed(t, c)
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("idle conn closed after %s, want IdleTimeout", d)
+	}
+}
+
+// 闲置时间超过ReadTimeout、未超过IdleTimeout的长连接仍可用
+func TestServerIdleTimeoutLongerThanReadTimeout(t *testing.T) {
+	s := &Server{
+		Handler:     func(ctx *RequestCtx) { ctx.WriteString("ok") },
+		ReadTimeout: 100 * time.Millisecond,
+		IdleTimeout: 5 * time.Second,
+	}
+	ln, _ := startInmemoryServer(t, s)
+	c := dialInmemory(t, ln)
+	br := bufio.NewReader(c)
+	testDoRaw(t, c, br, testGetRequest)
+	time.Sleep(300 * time.Millisecond)
+	if resp := testDoRaw(t, c, br, testGetRequest); string(resp.Body()) != "ok" {
+		t.Fatalf("unexpected body %q", resp.Body())
+	}
+}
+
+// 未设置IdleTimeout时，闲置长连接按ReadTimeout关闭
+func TestServerIdleTimeoutDefault(t *testing.T) {
+	s := &Server{
+		Handler:     func(ctx *RequestCtx) { ctx.WriteString("ok") },
+		ReadTimeout: 100 * time.Millisecond,
+	}
+	ln, _ := startInmemoryServer(t, s)
+	c := dialInmemory(t, ln)
+	testDoRaw(t, c, bufio.NewReader(c), testGetRequest)
+	expectConnClosed(t, c)
+}
+
+// 读取响应并检查状态码
+func expectStatusCode(t *testing.T, c net.Conn, statusCode int) {
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var resp Response
+	if err := resp.Read(bufio.NewReader(c)); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode() != statusCode {
+		t.Fatalf("unexpected status code %d, want %d", resp.StatusCode(), statusCode)
+	}
+}
+
+// 头部未在HeaderReadTimeout内读完时，响应StatusRequestTimeout
+func TestServerHeaderReadTimeout(t *testing.T) {
+	s := &Server{
+		Handler:           func(ctx *RequestCtx) { ctx.WriteString("ok") },
+		ReadTimeout:       10 * time.Second,
+		HeaderReadTimeout: 100 * time.Millisecond,
+	}
+	ln, _ := startInmemoryServer(t, s)
+	c := dialInmemory(t, ln)
+	c.Write([]byte("GET / HTTP/1.1\r\nHost"))
+
+	start := time.Now()
+	expectStatusCode(t, c, StatusRequestTimeout)
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("header read timed out after %s, want HeaderReadTimeout", d)
+	}
+}
+
+// HeaderReadTimeout不限制body的读取
+func TestServerHeaderReadTimeoutBody(t *testing.T) {
+	s := &Server{
+		Handler:           func(ctx *RequestCtx) { ctx.Write(ctx.PostBody()) },
+		HeaderReadTimeout: 100 * time.Millisecond,
+	}
+	ln, _ := startInmemoryServer(t, s)
+	c := dialInmemory(t, ln)
+	c.Write([]byte("POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\n\r\n"))
+	time.Sleep(300 * time.Millisecond)
+	c.Write([]byte("hello"))
+
+	var resp Response
+	if err := resp.Read(bufio.NewReader(c)); err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.Body()) != "hello" {
+		t.Fatalf("unexpected body %q", resp.Body())
+	}
+}
+
+// body停滞时，按MinRequestBodyRate超时
+func TestServerMinRequestBodyRateStalled(t *testing.T) {
+	s := &Server{
+		Handler:            func(ctx *RequestCtx) { ctx.WriteString("ok") },
+		MinRequestBodyRate: 1000,
+	}
+	ln, _ := startInmemoryServer(t, s)
+	c := dialInmemory(t, ln)
+	c.Write([]byte("POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 100000\r\n\r\n"))
+	c.Write(make([]byte, 100))
+
+	start := time.Now()
+	expectStatusCode(t, c, StatusRequestTimeout)
+	if d := time.Since(start); d > 3*time.Second {
+		t.Fatalf("stalled body timed out after %s", d)
+	}
+}
+
+// 慢速但不低于MinRequestBodyRate的上传，超过宽限时间后仍可完成
+func TestServerMinRequestBodyRateSlow(t *testing.T) {
+	const chunks, chunkSize = 8, 500
+	s := &Server{
+		Handler:            func(ctx *RequestCtx) { ctx.SetBodyString(strconv.Itoa(len(ctx.PostBody()))) },
+		MinRequestBodyRate: 1000,
+	}
+	ln, _ := startInmemoryServer(t, s)
+	c := dialInmemory(t, ln)
+	c.Write([]byte("POST / HTTP/1.1\r\nHost: a\r\nContent-Length: " + strconv.Itoa(chunks*chunkSize) + "\r\n\r\n"))
+	for i := 0; i < chunks; i++ {
+		time.Sleep(200 * time.Millisecond)
+		c.Write(make([]byte, chunkSize))
+	}
+
+	var resp Response
+	if err := resp.Read(bufio.NewReader(c)); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode() != StatusOK || string(resp.Body()) != strconv.Itoa(chunks*chunkSize) {
+		t.Fatalf("unexpected response %d %q", resp.StatusCode(), resp.Body())
+	}
+}
+
+// 运行s(默认Concurrency:1),返回已占用服务协程的连接;close(release)后其请求完成
+func startBusyServer(t *testing.T, s *Server) (ln *selffasthttputil.InmemoryListener, busy net.Conn, release chan struct{}) {
+	started := make(chan struct{}, 1)
+	release = make(chan struct{})
+	if s.Concurrency == 0 {
+		s.Concurrency = 1
+	}
+	s.Handler = func(ctx *RequestCtx) {
+		if string(ctx.Path()) == "/block" {
+			started <- struct{}{}
+			<-release
+		}
+		ctx.WriteString("ok")
+	}
+	ln, _ = startInmemoryServer(t, s)
+	busy = dialInmemory(t, ln)
+	busy.Write([]byte("GET /block HTTP/1.1\r\nHost: a\r\n\r\n"))
+	<-started
+	return ln, busy, release
+}
+
+func TestServerOverloadReject(t *testing.T) {
+	s := &Server{
+		OverloadStatusCode: StatusTooManyRequests,
+		OverloadMessage:    "busy",
+	}
+	ln, _, release := startBusyServer(t, s)
+	defer close(release)
+
+	c := dialInmemory(t, ln)
+	var resp Response
+	if err := resp.Read(bufio.NewReader(c)); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode() != StatusTooManyRequests || string(resp.Body()) != "busy" {
+		t.Fatalf("unexpected response %d %q", resp.StatusCode(), resp.Body())
+	}
+	expectConnClosed(t, c)
+	if n := s.Stats().RejectedConcurrency; n != 1 {
+		t.Fatalf("unexpected RejectedConcurrency %d, want 1", n)
+	}
+}
+
+func TestServerOverloadClose(t *testing.T) {
+	s := &Server{OverloadPolicy: OverloadClose}
+	ln, _, release := startBusyServer(t, s)
+	defer close(release)
+
+	c := dialInmemory(t, ln)
+	expectConnClosed(t, c) // 未写入任何响应
+}
+
+// 排队的连接在服务协程空闲后得到服务
+func TestServerOverloadQueue(t *testing.T) {
+	s := &Server{
+		OverloadPolicy:       OverloadQueue,
+		OverloadQueueTimeout: 5 * time.Second,
+	}
+	ln, busy, release := startBusyServer(t, s)
+
+	c := dialInmemory(t, ln)
+	c.Write([]byte(testGetRequest))
+	time.Sleep(100 * time.Millisecond)
+
+	close(release)
+	var resp Response
+	if err := resp.Read(bufio.NewReader(busy)); err != nil {
+		t.Fatal(err)
+	}
+	busy.Close() // 释放服务协程
+
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	if err := resp.Read(bufio.NewReader(c)); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode() != StatusOK || string(resp.Body()) != "ok" {
+		t.Fatalf("unexpected response %d %q", resp.StatusCode(), resp.Body())
+	}
+	if n := s.Stats().RejectedConcurrency; n != 0 {
+		t.Fatalf("unexpected RejectedConcurrency %d, want 0", n)
+	}
+}
+
+// 排队超时后按OverloadReject处理
+func TestServerOverloadQueueTimeout(t *testing.T) {
+	s := &Server{
+		OverloadPolicy:       OverloadQueue,
+		OverloadQueueTimeout: 100 * time.Millisecond,
+	}
+	ln, _, release := startBusyServer(t, s)
+	defer close(release)
+
+	c := dialInmemory(t, ln)
+	start := time.Now()
+	expectStatusCode(t, c, StatusServiceUnavailable)
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("queued conn rejected after %s, want OverloadQueueTimeout", d)
+	}
+}
+
+func TestServerMaxConns(t *testing.T) {
+	s := &Server{MaxConns: 1, Concurrency: 10} // 仅受MaxConns限制
+	ln, _, release := startBusyServer(t, s)
+	defer close(release)
+
+	c := dialInmemory(t, ln)
+	expectStatusCode(t, c, StatusServiceUnavailable)
+	if n := s.Stats().RejectedMaxConns; n != 1 {
+		t.Fatalf("unexpected RejectedMaxConns %d, want 1", n)
+	}
+}
+
+// 将日志发送到通道
+type chanLogger chan string
+
+func (l chanLogger) Printf(format string, args ...interface{}) {
+	select {
+	case l <- fmt.Sprintf(format, args...):
+	default:
+	}
+}
+
+func TestServerPanicDefault(t *testing.T) {
+	logCh := make(chanLogger, 4)
+	s := &Server{
+		Handler: func(ctx *RequestCtx) { panic("boom") },
+		Logger:  logCh,
+	}
+	ln, _ := startInmemoryServer(t, s)
+	c := dialInmemory(t, ln)
+	resp := testDoRaw(t, c, bufio.NewReader(c), testGetRequest)
+	if resp.StatusCode() != StatusInternalServerError || !resp.ConnectionClose() {
+		t.Fatalf("unexpected response %d, Connection: close=%v", resp.StatusCode(), resp.ConnectionClose())
+	}
+	expectConnClosed(t, c)
+	if msg := <-logCh; !strings.Contains(msg, "boom") {
+		t.Fatalf("unexpected log %q", msg)
+	}
+	if n := s.Stats().Panics; n != 1 {
+		t.Fatalf("unexpected Panics %d, want 1", n)
+	}
+
+	// 服务协程继续服务其它连接
+	c = dialInmemory(t, ln)
+	if resp := testDoRaw(t, c, bufio.NewReader(c), testGetRequest); resp.StatusCode() != StatusInternalServerError {
+		t.Fatalf("unexpected status code %d", resp.StatusCode())
+	}
+}
+
+func TestServerPanicHandler(t *testing.T) {
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.WriteString("partial")
+			panic("boom")
+		},
+		PanicHandler: func(ctx *RequestCtx, r interface{}) {
+			if !strings.Contains(string(debug.Stack()), "TestServerPanicHandler") {
+				t.Errorf("stack of the panic is unwound")
+			}
+			ctx.SetStatusCode(StatusBadGateway)
+			ctx.WriteString(fmt.Sprint(r))
+		},
+	}
+	ln, _ := startInmemoryServer(t, s)
+	c := dialInmemory(t, ln)
+	resp := testDoRaw(t, c, bufio.NewReader(c), testGetRequest)
+	if resp.StatusCode() != StatusBadGateway || string(resp.Body()) != "boom" || !resp.ConnectionClose() {
+		t.Fatalf("unexpected response %d %q, Connection: close=%v", resp.StatusCode(), resp.Body(), resp.ConnectionClose())
+	}
+	if n := s.Stats().Panics; n != 1 {
+		t.Fatalf("unexpected Panics %d, want 1", n)
+	}
+}
+
+// StreamWriter中的panic:记录到Server.Logger,计入Panics,不完整的body不被当作完整响应
+func TestServerStreamWriterPanic(t *testing.T) {
+	logCh := make(chanLogger, 4)
+	s := &Server{
+		Handler: func(ctx *RequestCtx) {
+			ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+				w.WriteString("partial")
+				w.Flush()
+				panic("boom")
+			})
+		},
+		PanicHandler: func(ctx *RequestCtx, r interface{}) { t.Errorf("unexpected PanicHandler call") },
+		Logger:       logCh,
+	}
+	ln, _ := startInmemoryServer(t, s)
+	c := dialInmemory(t, ln)
+	c.Write([]byte(testGetRequest))
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	var resp Response
+	if err := resp.Read(bufio.NewReader(c)); err == nil {
+		t.Fatalf("expecting error for truncated body, got %q", resp.Body())
+	}
+	for logged := false; !logged; { // 另有写响应出错的日志
+		select {
+		case msg := <-logCh:
+			logged = strings.HasPrefix(msg, "panic in StreamWriter: boom\ngoroutine ")
+		case <-time.After(time.Second):
+			t.Fatal("panic is not logged to Server.Logger")
+		}
+	}
+	if n := s.Stats().Panics; n != 1 {
+		t.Fatalf("unexpected Panics %d, want 1", n)
+	}
+}
+
+func TestServerErrorHandler(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		req        string
+		isErr      func(err error) bool
+		statusCode int // 未设置ErrorHandler时的响应码
+	}{
+		{"small buffer", "GET / HTTP/1.1\r\nHost: a\r\nX: " + strings.Repeat("x", 2048) + "\r\n\r\n",
+			func(err error) bool { _, ok := err.(*ErrSmallBuffer); return ok }, StatusRequestHeaderFieldsTooLarge},
+		{"body too large", "POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 100\r\n\r\n",
+			func(err error) bool { return err == ErrBodyTooLarge }, StatusRequestEntityTooLarge},
+		{"timeout", "GET / HTTP/1.1\r\nHost",
+			func(err error) bool { _, ok := err.(*ErrReadTimeout); return ok }, StatusRequestTimeout},
+		{"bad request", "GARBAGE\r\n\r\n",
+			func(err error) bool { _, ok := err.(*ErrBadRequest); return ok }, StatusBadRequest},
+	} {
+		newServer := func() *Server {
+			return &Server{
+				Handler:            func(ctx *RequestCtx) { ctx.WriteString("ok") },
+				ReadBufferSize:     1024,
+				MaxRequestBodySize: 10,
+				HeaderReadTimeout:  100 * time.Millisecond,
+			}
+		}
+
+		s := newServer()
+		errCh := make(chan error, 1)
+		s.ErrorHandler = func(ctx *RequestCtx, err error) {
+			errCh <- err
+			ctx.SetStatusCode(StatusTeapot)
+			ctx.SetContentType("application/json")
+			ctx.WriteString(`{"error":true}`)
+		}
+		ln, _ := startInmemoryServer(t, s)
+		c := dialInmemory(t, ln)
+		c.Write([]byte(tc.req))
+		c.SetReadDeadline(time.Now().Add(time.Second))
+		var resp Response
+		if err := resp.Read(bufio.NewReader(c)); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if resp.StatusCode() != StatusTeapot || string(resp.Body()) != `{"error":true}` || !resp.ConnectionClose() {
+			t.Fatalf("%s: unexpected response %d %q, Connection: close=%v", tc.name, resp.StatusCode(), resp.Body(), resp.ConnectionClose())
+		}
+		if err := <-errCh; !tc.isErr(err) {
+			t.Fatalf("%s: unexpected error %T %v", tc.name, err, err)
+		}
+
+		// 默认响应
+		ln, _ = startInmemoryServer(t, newServer())
+		c = dialInmemory(t, ln)
+		c.Write([]byte(tc.req))
+		expectStatusCode(t, c, tc.statusCode)
+	}
+}
+
+// 端口为0时，各监听器共用同一端口
+func TestServerListenAndServeMultiPortZero(t *testing.T) {
+	const n = 3
+	s := &Server{
+		Handler: func(ctx *RequestCtx) { ctx.WriteString("ok") },
+		Logger:  &testLogger{},
+	}
+	serveCh := make(chan error, 1)
+	go func() { serveCh <- s.ListenAndServeMulti("127.0.0.1:0", n) }()
+
+	var addrs []string
+	for start := time.Now(); len(addrs) < n; time.Sleep(10 * time.Millisecond) {
+		select {
+		case err := <-serveCh:
+			if err == reuseport.ErrNoReusePort {
+				t.Skip(err)
+			}
+			t.Fatalf("unexpected error %v", err)
+		default:
+		}
+		if time.Since(start) > time.Second {
+			t.Fatalf("timeout waiting for %d listeners", n)
+		}
+		addrs = addrs[:0]
+		s.mu.Lock()
+		for _, ln := range s.ln {
+			addrs = append(addrs, ln.Addr().String())
+		}
+		s.mu.Unlock()
+	}
+	for _, addr := range addrs[1:] {
+		if addr != addrs[0] {
+			t.Fatalf("listeners on different addresses %q", addrs)
+		}
+	}
+
+	c, err := net.Dial("tcp4", addrs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if resp := testDoRaw(t, c, bufio.NewReader(c), testGetRequest); string(resp.Body()) != "ok" {
+		t.Fatalf("unexpected body %q", resp.Body())
+	}
+	c.Close()
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := <-serveCh; err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
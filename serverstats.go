@@ -0,0 +1,127 @@
+package selfFastHttp
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+)
+
+// Server运行统计快照,见Server.Stats
+type ServerStats struct {
+	// 当前正在服务的连接数(不含被劫持的连接)
+	OpenConns int
+
+	// 工作协程数:处理连接中的 / 闲置等待的
+	BusyWorkers int
+	IdleWorkers int
+
+	// 已处理的请求数
+	RequestsServed uint64
+
+	// 从连接读取 / 向连接写入的字节数
+	// 不含被劫持后的读写;连接结束时才计入
+	BytesRead    uint64
+	BytesWritten uint64
+
+	// 各ip(前缀)的当前连接数,见perIPConnCounter.Snapshot
+	// 仅当设置了MaxConnsPerIP时统计
+	ConnsPerIP map[string]int
+
+	// 被劫持的连接数
+	HijackedConns uint64
+
+	// 因Concurrency满载,被拒绝的连接数
+	RejectedConcurrency uint64
+
+	// 因MaxConns,被拒绝的连接数
+	RejectedMaxConns uint64
+
+	// 因MaxConnsPerIP,被拒绝的连接数
+	RejectedPerIP uint64
+
+	// 因RequestRateLimit,被拒绝的请求数
+	RejectedRateLimit uint64
+
+	// 恢复的panic数,见Server.PanicHandler
+	Panics uint64
+}
+
+// 返回Server当前的运行统计
+// 可并发调用,适合定时采集,用于监控
+func (s *Server) Stats() ServerStats {
+	st := ServerStats{
+		OpenConns:           int(atomic.LoadInt32(&s.open)),
+		RequestsServed:      atomic.LoadUint64(&s.requestsServed),
+		BytesRead:           atomic.LoadUint64(&s.bytesRead),
+		BytesWritten:        atomic.LoadUint64(&s.bytesWritten),
+		ConnsPerIP:          s.perIPConnCounter.Snapshot(),
+		HijackedConns:       atomic.LoadUint64(&s.hijackedConns),
+		RejectedConcurrency: atomic.LoadUint64(&s.rejectedConcurrency),
+		RejectedMaxConns:    atomic.LoadUint64(&s.rejectedMaxConns),
+		RejectedPerIP:       atomic.LoadUint64(&s.rejectedPerIP),
+		RejectedRateLimit:   atomic.LoadUint64(&s.rejectedRateLimit),
+		Panics:              atomic.LoadUint64(&s.panics),
+	}
+
+	s.wpLock.Lock()
+	for _, wp := range s.wps {
+		workers, idle := wp.stats()
+		st.BusyWorkers += workers - idle
+		st.IdleWorkers += idle
+	}
+	s.wpLock.Unlock()
+	return st
+}
+
+func (s *Server) registerWorkerPool(wp *workerPool) {
+	s.wpLock.Lock()
+	s.wps = append(s.wps, wp)
+	s.wpLock.Unlock()
+}
+
+func (s *Server) unregisterWorkerPool(wp *workerPool) {
+	s.wpLock.Lock()
+	for i, x := range s.wps {
+		if x == wp {
+			s.wps = append(s.wps[:i], s.wps[i+1:]...)
+			break
+		}
+	}
+	s.wpLock.Unlock()
+}
+
+// 统计读写字节数的连接读写器
+// 内嵌于RequestCtx,作为读写缓冲器的底层,无额外内存分配
+// 字节数先计入所属连接,连接结束时再汇总到Server,避免各连接争用同一计数器
+type statsConnIO struct {
+	c  net.Conn  // 写
+	r  io.Reader // 读:连接或其包装(见minRateReader)
+	tc *trackedConn
+}
+
+func (cio *statsConnIO) Read(p []byte) (int, error) {
+	n, err := cio.r.Read(p)
+	if n > 0 {
+		atomic.AddUint64(&cio.tc.bytesRead, uint64(n))
+	}
+	return n, err
+}
+
+func (cio *statsConnIO) Write(p []byte) (int, error) {
+	n, err := cio.c.Write(p)
+	if n > 0 {
+		atomic.AddUint64(&cio.tc.bytesWritten, uint64(n))
+	}
+	return n, err
+}
+
+// 将连接的读写字节数汇总到Server
+// serveConn返回时调用
+func (s *Server) flushConnStats(tc *trackedConn) {
+	if n := atomic.SwapUint64(&tc.bytesRead, 0); n > 0 {
+		atomic.AddUint64(&s.bytesRead, n)
+	}
+	if n := atomic.SwapUint64(&tc.bytesWritten, 0); n > 0 {
+		atomic.AddUint64(&s.bytesWritten, n)
+	}
+}
@@ -0,0 +1,61 @@
+package selfFastHttp
+
+import (
+	"fmt"
+	"net"
+)
+
+// 设置ListenAndServe*创建的监听器的socket选项
+// TCPDeferAccept,TCPFastOpen,SocketReadBuffer,SocketWriteBuffer
+// 监听器上的设置，由之后接受的连接继承
+func (s *Server) tuneListener(ln net.Listener) error {
+	if s.TCPDeferAccept <= 0 && s.TCPFastOpen <= 0 && s.SocketReadBuffer <= 0 && s.SocketWriteBuffer <= 0 {
+		return nil
+	}
+	tl, ok := ln.(*net.TCPListener)
+	if !ok {
+		return nil
+	}
+	rc, err := tl.SyscallConn()
+	if err != nil {
+		return err
+	}
+	if cerr := rc.Control(func(fd uintptr) {
+		err = s.setListenerSockopts(fd)
+	}); cerr != nil {
+		return cerr
+	}
+	if err != nil {
+		return fmt.Errorf("cannot set socket options on %q: %s", ln.Addr(), err)
+	}
+	return nil
+}
+
+// 设置新连接的socket选项
+// TCPKeepalivePeriod,DisableTCPNoDelay,SocketReadBuffer,SocketWriteBuffer,TCPUserTimeout
+// 连接可能已被对端关闭，忽略错误
+func (s *Server) tuneConn(c net.Conn) {
+	tc, ok := unwrapConn(c, nil).(*net.TCPConn)
+	if !ok {
+		return
+	}
+	if s.TCPKeepalivePeriod > 0 {
+		tc.SetKeepAlive(true)
+		tc.SetKeepAlivePeriod(s.TCPKeepalivePeriod)
+	} else if s.TCPKeepalivePeriod < 0 {
+		tc.SetKeepAlive(false)
+	}
+	if s.DisableTCPNoDelay {
+		tc.SetNoDelay(false)
+	}
+	if s.SocketReadBuffer > 0 {
+		tc.SetReadBuffer(s.SocketReadBuffer)
+	}
+	if s.SocketWriteBuffer > 0 {
+		tc.SetWriteBuffer(s.SocketWriteBuffer)
+	}
+	if s.TCPUserTimeout > 0 {
+		setTCPUserTimeout(tc, s.TCPUserTimeout)
+	}
+}
+
@@ -0,0 +1,55 @@
+//go:build linux
+// +build linux
+
+package selfFastHttp
+
+import (
+	"net"
+	"syscall"
+	"time"
+)
+
+// syscall包未定义
+const (
+	tcpFastOpen    = 0x17 // TCP_FASTOPEN
+	tcpUserTimeout = 0x12 // TCP_USER_TIMEOUT
+)
+
+func (s *Server) setListenerSockopts(fd uintptr) error {
+	if s.TCPDeferAccept > 0 {
+		secs := int((s.TCPDeferAccept + time.Second - 1) / time.Second)
+		if err := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_DEFER_ACCEPT, secs); err != nil {
+			return err
+		}
+	}
+	if s.TCPFastOpen > 0 {
+		if err := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, tcpFastOpen, s.TCPFastOpen); err != nil {
+			return err
+		}
+	}
+	if s.SocketReadBuffer > 0 {
+		if err := syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF, s.SocketReadBuffer); err != nil {
+			return err
+		}
+	}
+	if s.SocketWriteBuffer > 0 {
+		if err := syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF, s.SocketWriteBuffer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setTCPUserTimeout(c *net.TCPConn, d time.Duration) error {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return err
+	}
+	ms := int(d / time.Millisecond)
+	if cerr := rc.Control(func(fd uintptr) {
+		err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, tcpUserTimeout, ms)
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
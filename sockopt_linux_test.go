@@ -0,0 +1,126 @@
+//go:build linux
+// +build linux
+
+package selfFastHttp
+
+import (
+	"net"
+	"net/netip"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func testSockopt(t *testing.T, c syscall.Conn, level, opt int) int {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v int
+	if cerr := rc.Control(func(fd uintptr) {
+		v, err = syscall.GetsockoptInt(int(fd), level, opt)
+	}); cerr != nil {
+		t.Fatal(cerr)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestServerTuneListener(t *testing.T) {
+	s := &Server{
+		TCPDeferAccept:   2 * time.Second,
+		TCPFastOpen:      16,
+		SocketReadBuffer: 64 * 1024,
+	}
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if err := s.tuneListener(ln); err != nil {
+		t.Fatal(err)
+	}
+	tl := ln.(*net.TCPListener)
+	if v := testSockopt(t, tl, syscall.IPPROTO_TCP, syscall.TCP_DEFER_ACCEPT); v <= 0 {
+		t.Errorf("unexpected TCP_DEFER_ACCEPT %d", v)
+	}
+	if v := testSockopt(t, tl, syscall.IPPROTO_TCP, tcpFastOpen); v != 16 {
+		t.Errorf("unexpected TCP_FASTOPEN %d, want 16", v)
+	}
+	if v := testSockopt(t, tl, syscall.SOL_SOCKET, syscall.SO_RCVBUF); v < 64*1024 {
+		t.Errorf("unexpected SO_RCVBUF %d", v)
+	}
+
+	// 非TCP监听器忽略
+	uln, err := net.Listen("unix", t.TempDir()+"/a.sock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer uln.Close()
+	if err := s.tuneListener(uln); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestServerTuneConn(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accept := func() *net.TCPConn {
+		cc, err := net.Dial("tcp4", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { cc.Close() })
+		c, err := ln.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close() })
+		return c.(*net.TCPConn)
+	}
+
+	s := &Server{
+		TCPKeepalivePeriod: 7 * time.Second,
+		DisableTCPNoDelay:  true,
+		SocketWriteBuffer:  64 * 1024,
+		TCPUserTimeout:     3 * time.Second,
+	}
+	c := accept()
+	var counter perIPConnCounter
+	ip := netip.MustParsePrefix("127.0.0.1/32")
+	counter.Register(ip)
+	s.tuneConn(newPerIPConn(c, ip, &counter)) // 设置被封装的连接
+	for _, tc := range []struct {
+		name       string
+		level, opt int
+		want       int
+	}{
+		{"SO_KEEPALIVE", syscall.SOL_SOCKET, syscall.SO_KEEPALIVE, 1},
+		{"TCP_KEEPIDLE", syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE, 7},
+		{"TCP_NODELAY", syscall.IPPROTO_TCP, syscall.TCP_NODELAY, 0},
+		{"TCP_USER_TIMEOUT", syscall.IPPROTO_TCP, tcpUserTimeout, 3000},
+	} {
+		if v := testSockopt(t, c, tc.level, tc.opt); v != tc.want {
+			t.Errorf("unexpected %s %d, want %d", tc.name, v, tc.want)
+		}
+	}
+	if v := testSockopt(t, c, syscall.SOL_SOCKET, syscall.SO_SNDBUF); v < 64*1024 {
+		t.Errorf("unexpected SO_SNDBUF %d", v)
+	}
+
+	// <0时关闭keepalive
+	s = &Server{TCPKeepalivePeriod: -1}
+	c = accept()
+	s.tuneConn(c)
+	if v := testSockopt(t, c, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE); v != 0 {
+		t.Errorf("unexpected SO_KEEPALIVE %d, want 0", v)
+	}
+	if v := testSockopt(t, c, syscall.IPPROTO_TCP, syscall.TCP_NODELAY); v != 1 {
+		t.Errorf("unexpected TCP_NODELAY %d, want 1", v)
+	}
+}
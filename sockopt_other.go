@@ -0,0 +1,20 @@
+//go:build !linux
+// +build !linux
+
+package selfFastHttp
+
+import (
+	"net"
+	"time"
+)
+
+// TCPDeferAccept,TCPFastOpen仅linux支持，忽略之
+// SocketReadBuffer,SocketWriteBuffer在tuneConn中设置
+func (s *Server) setListenerSockopts(fd uintptr) error {
+	return nil
+}
+
+// TCP_USER_TIMEOUT仅linux支持，忽略之
+func setTCPUserTimeout(c *net.TCPConn, d time.Duration) error {
+	return nil
+}
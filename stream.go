@@ -2,7 +2,9 @@ package selfFastHttp
 
 import (
 	"bufio"
+	"fmt"
 	"io"
+	"runtime/debug"
 	"sync"
 
 	"github.com/forTWOS/selfFastHttp/selffasthttputil"
@@ -19,6 +21,12 @@ type StreamWriter func(w *bufio.Writer)
 // 这个reader有可能传到Response.SetBodyStream
 // 返回reader中，当所有请求的数据被读完，须调用Close；否则goroutine有可能泄漏
 func NewStreamReader(sw StreamWriter) io.ReadCloser {
+	return newStreamReader(sw, nil)
+}
+
+// onPanic:sw panic时的回调,在恢复的defer中调用，可用debug.Stack()取得调用栈
+// 为nil时，记录到defaultLogger
+func newStreamReader(sw StreamWriter, onPanic func(r interface{})) io.ReadCloser {
 	pc := selffasthttputil.NewPipeConns()
 	pw := pc.Conn1()
 	pr := pc.Conn2()
@@ -33,6 +41,18 @@ func NewStreamReader(sw StreamWriter) io.ReadCloser {
 	}
 
 	go func() {
+		// sw在该协程中运行,其panic不经过Server的恢复处理,须在此恢复
+		// reader随即返回错误，而非io.EOF，以免不完整的数据被当作完整的body
+		defer func() {
+			if r := recover(); r != nil {
+				if onPanic != nil {
+					onPanic(r)
+				} else {
+					defaultLogger.Printf("panic in StreamWriter: %v\n%s", r, debug.Stack())
+				}
+				pc.CloseWithError(fmt.Errorf("panic in StreamWriter: %v", r))
+			}
+		}()
 		sw(bw)
 		bw.Flush()
 		pw.Close()
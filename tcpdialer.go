@@ -0,0 +1,33 @@
+package selfFastHttp
+
+import (
+	"net"
+	"time"
+)
+
+// 建立连接的默认超时时间
+const DefaultDialTimeout = 3 * time.Second
+
+// 建立TCP连接,用于Client.Dial、HostClient.Dial
+type DialFunc func(addr string) (net.Conn, error)
+
+// 建立到addr的TCP4连接,超时时间为DefaultDialTimeout
+// addr须包含端口,如"foobar.com:80"、"1.2.3.4:443"
+func Dial(addr string) (net.Conn, error) {
+	return DialTimeout(addr, DefaultDialTimeout)
+}
+
+// 建立到addr的TCP4连接
+func DialTimeout(addr string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout("tcp4", addr, timeout)
+}
+
+// 同Dial,支持IPv6
+func DialDualStack(addr string) (net.Conn, error) {
+	return DialDualStackTimeout(addr, DefaultDialTimeout)
+}
+
+// 同DialTimeout,支持IPv6
+func DialDualStackTimeout(addr string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout("tcp", addr, timeout)
+}
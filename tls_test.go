@@ -0,0 +1,208 @@
+package selfFastHttp
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// 生成自签名证书,返回pem格式的证书、私钥
+func newTestCert(t *testing.T, cn string, dnsNames ...string) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func startTestTLSServer(t *testing.T, s *Server, certData, keyData []byte) string {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	if s.Logger == nil {
+		s.Logger = &testLogger{}
+	}
+	go s.ServeTLSEmbed(ln, certData, keyData)
+	return ln.Addr().String()
+}
+
+type testLogger struct{}
+
+func (testLogger) Printf(string, ...interface{}) {}
+
+// 返回服务端证书的CommonName
+func tlsPeerCN(t *testing.T, addr, serverName string) string {
+	c, err := tls.Dial("tcp4", addr, &tls.Config{InsecureSkipVerify: true, ServerName: serverName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	return c.ConnectionState().PeerCertificates[0].Subject.CommonName
+}
+
+func TestServerAppendCertSNI(t *testing.T) {
+	baseCert, baseKey := newTestCert(t, "base")
+	base, err := tls.X509KeyPair(baseCert, baseKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs := make([]tls.Certificate, 1, 4) // 有空余容量:追加时不可写入调用者的底层数组
+	certs[0] = base
+	cfg := &tls.Config{Certificates: certs}
+
+	s := &Server{Handler: func(ctx *RequestCtx) {}, TLSConfig: cfg}
+	for _, name := range []string{"a.example", "b.example"} {
+		certData, keyData := newTestCert(t, name, name)
+		if err := s.AppendCertEmbed(certData, keyData); err != nil {
+			t.Fatal(err)
+		}
+	}
+	certData, keyData := newTestCert(t, "c.example", "c.example")
+	addr := startTestTLSServer(t, s, certData, keyData)
+
+	for _, tc := range []struct {
+		serverName string
+		cn         string
+	}{
+		{"a.example", "a.example"},
+		{"b.example", "b.example"},
+		{"c.example", "c.example"},
+		{"unknown.example", "base"}, // 无匹配时，使用第1个证书
+	} {
+		if cn := tlsPeerCN(t, addr, tc.serverName); cn != tc.cn {
+			t.Errorf("SNI %q: got certificate %q, want %q", tc.serverName, cn, tc.cn)
+		}
+	}
+	if len(cfg.Certificates) != 1 || len(certs[:2][1].Certificate) != 0 {
+		t.Fatalf("Server.TLSConfig has been modified")
+	}
+}
+
+func TestServerTLSNoCertificates(t *testing.T) {
+	for _, serve := range []func(s *Server, ln net.Listener) error{
+		func(s *Server, ln net.Listener) error { return s.ServeTLS(ln, "", "") },
+		func(s *Server, ln net.Listener) error { return s.ServeTLSEmbed(ln, nil, nil) },
+	} {
+		ln, err := net.Listen("tcp4", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := &Server{Handler: func(ctx *RequestCtx) {}}
+		if err := serve(s, ln); err != errNoCertificates {
+			t.Errorf("unexpected error %v, want %v", err, errNoCertificates)
+		}
+		ln.Close()
+	}
+}
+
+func TestCertSubjectAllowed(t *testing.T) {
+	u, _ := url.Parse("spiffe://example.org/svc")
+	cert := &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "client-1"},
+		DNSNames:       []string{"svc.example"},
+		EmailAddresses: []string{"ops@example.org"},
+		IPAddresses:    []net.IP{net.ParseIP("10.0.0.7")},
+		URIs:           []*url.URL{u},
+	}
+	noCN := &x509.Certificate{DNSNames: []string{"svc.example"}}
+
+	for _, tc := range []struct {
+		cert    *x509.Certificate
+		allowed []string
+		ok      bool
+	}{
+		{cert, []string{"client-1"}, true},
+		{cert, []string{"svc.example"}, true},
+		{cert, []string{"ops@example.org"}, true},
+		{cert, []string{"10.0.0.7"}, true},
+		{cert, []string{"spiffe://example.org/svc"}, true},
+		{cert, []string{"client-2", "other.example"}, false},
+		{cert, nil, false},
+		{noCN, []string{""}, false}, // 空CommonName不匹配
+		{noCN, []string{"svc.example"}, true},
+	} {
+		allowed := make(map[string]struct{})
+		for _, s := range tc.allowed {
+			allowed[s] = struct{}{}
+		}
+		if ok := certSubjectAllowed(tc.cert, allowed); ok != tc.ok {
+			t.Errorf("certSubjectAllowed(%q, %q)=%v, want %v", tc.cert.Subject.CommonName, tc.allowed, ok, tc.ok)
+		}
+	}
+}
+
+func TestClientCertHandler(t *testing.T) {
+	clientCert, clientKey := newTestCert(t, "client-1")
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(clientCert)
+
+	h := ClientCertHandler(func(ctx *RequestCtx) {
+		ctx.WriteString(ctx.ClientCertSubjectCN())
+	}, []string{"client-1"})
+	s := &Server{Handler: h, ClientCAs: pool, ClientAuth: tls.VerifyClientCertIfGiven}
+	certData, keyData := newTestCert(t, "server")
+	addr := startTestTLSServer(t, s, certData, keyData)
+
+	cc, err := tls.X509KeyPair(clientCert, clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		certs      []tls.Certificate
+		statusCode int
+		body       string
+	}{
+		{[]tls.Certificate{cc}, StatusOK, "client-1"},
+		{nil, StatusForbidden, ""},
+	} {
+		c, err := tls.Dial("tcp4", addr, &tls.Config{InsecureSkipVerify: true, Certificates: tc.certs})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Write([]byte("GET / HTTP/1.1\r\nHost: a\r\n\r\n"))
+		var resp Response
+		if err := resp.Read(bufio.NewReader(c)); err != nil {
+			t.Fatal(err)
+		}
+		c.Close()
+		if resp.StatusCode() != tc.statusCode {
+			t.Fatalf("unexpected status code %d, want %d", resp.StatusCode(), tc.statusCode)
+		}
+		if tc.statusCode == StatusOK && string(resp.Body()) != tc.body {
+			t.Fatalf("unexpected body %q, want %q", resp.Body(), tc.body)
+		}
+	}
+}
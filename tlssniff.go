@@ -0,0 +1,117 @@
+package selfFastHttp
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+// tls端口上，明文http请求的处理方式,见Server.TLSPlaintext
+type TLSPlaintextMode int
+
+const (
+	// 所有连接按tls处理，明文请求导致握手失败
+	TLSPlaintextOff TLSPlaintextMode = iota
+
+	// 明文连接按普通http服务
+	TLSPlaintextServe
+
+	// 明文请求响应StatusPermanentRedirect,重定向到https
+	TLSPlaintextRedirect
+)
+
+// tls记录的首字节:握手(ClientHello)
+const tlsRecordTypeHandshake = 0x16
+
+// 探测连接首字节的默认超时时间,见Server.TLSSniffTimeout
+const DefaultTLSSniffTimeout = 5 * time.Second
+
+// 同一端口服务tls及明文http的监听器
+// 每个连接在单独的协程中读取首字节:tls握手的，按config处理;否则为明文连接
+type tlsSniffListener struct {
+	asyncAcceptor
+
+	config  *tls.Config
+	timeout time.Duration
+}
+
+func newTLSSniffListener(ln net.Listener, config *tls.Config, timeout time.Duration) net.Listener {
+	if timeout <= 0 {
+		timeout = DefaultTLSSniffTimeout
+	}
+	tln := &tlsSniffListener{
+		config:  config,
+		timeout: timeout,
+	}
+	tln.ln = ln
+	tln.prepare = tln.sniff
+	return tln
+}
+
+func (ln *tlsSniffListener) Addr() net.Addr {
+	return ln.ln.Addr()
+}
+
+func (ln *tlsSniffListener) sniff(c net.Conn) (net.Conn, error) {
+	var b [1]byte
+	c.SetReadDeadline(time.Now().Add(ln.timeout))
+	if _, err := c.Read(b[:]); err != nil {
+		return nil, err
+	}
+	c.SetReadDeadline(zeroTime)
+	sc := &sniffedConn{
+		Conn: c,
+		rest: append([]byte(nil), b[0]),
+	}
+	if b[0] == tlsRecordTypeHandshake {
+		return tls.Server(sc, ln.config), nil
+	}
+	return sc, nil
+}
+
+// 已读取首字节的连接
+// 未被tls.Conn封装的，为tls端口上的明文连接
+type sniffedConn struct {
+	net.Conn
+
+	rest []byte // 已读取的数据
+}
+
+func (c *sniffedConn) Read(p []byte) (int, error) {
+	if len(c.rest) > 0 {
+		n := copy(p, c.rest)
+		c.rest = c.rest[n:]
+		return n, nil
+	}
+	return c.Conn.Read(p)
+}
+
+// 检测c是否为tls端口上的明文连接
+func isPlaintextOnTLS(c net.Conn) bool {
+	_, ok := unwrapConn(c, isConnTLSerOrSniffed).(*sniffedConn)
+	return ok
+}
+
+// tls连接中的sniffedConn在tls.Conn之下，不是明文连接
+func isConnTLSerOrSniffed(c net.Conn) bool {
+	if _, ok := c.(*sniffedConn); ok {
+		return true
+	}
+	return isConnTLSer(c)
+}
+
+// 重定向到同一uri的https地址
+func (s *Server) redirectToHTTPS(ctx *RequestCtx) {
+	host := ctx.Host()
+	if len(host) == 0 {
+		ctx.Error("Plain HTTP request was sent to HTTPS port", StatusBadRequest)
+		return
+	}
+	url := append([]byte(nil), strHTTPS...)
+	url = append(url, strColonSlashSlash...)
+	url = append(url, host...)
+	url = append(url, ctx.RequestURI()...)
+	ctx.Response.Header.SetCanonical(strLocation, url)
+	ctx.SetStatusCode(StatusPermanentRedirect)
+	ctx.SetConnectionClose()
+}
@@ -0,0 +1,99 @@
+package selfFastHttp
+
+import (
+	"bufio"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+)
+
+// 启动TLSPlaintext模式的服务,Handler响应连接是否为tls
+func startTLSSniffServer(t *testing.T, s *Server) string {
+	s.Handler = func(ctx *RequestCtx) {
+		if ctx.IsTLS() {
+			ctx.WriteString("tls")
+		} else {
+			ctx.WriteString("plain")
+		}
+	}
+	certData, keyData := newTestCert(t, "server")
+	return startTestTLSServer(t, s, certData, keyData)
+}
+
+func dialTLSSniff(t *testing.T, addr string, useTLS bool) net.Conn {
+	var c net.Conn
+	var err error
+	if useTLS {
+		c, err = tls.Dial("tcp4", addr, &tls.Config{InsecureSkipVerify: true})
+	} else {
+		c, err = net.Dial("tcp4", addr)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestServerTLSPlaintextServe(t *testing.T) {
+	addr := startTLSSniffServer(t, &Server{TLSPlaintext: TLSPlaintextServe})
+	for _, useTLS := range []bool{false, true, false} {
+		c := dialTLSSniff(t, addr, useTLS)
+		br := bufio.NewReader(c)
+		want := map[bool]string{false: "plain", true: "tls"}[useTLS]
+		for i := 0; i < 2; i++ { // 长连接
+			if resp := testDoRaw(t, c, br, testGetRequest); string(resp.Body()) != want {
+				t.Fatalf("unexpected body %q, want %q", resp.Body(), want)
+			}
+		}
+	}
+}
+
+func TestServerTLSPlaintextRedirect(t *testing.T) {
+	addr := startTLSSniffServer(t, &Server{TLSPlaintext: TLSPlaintextRedirect})
+
+	c := dialTLSSniff(t, addr, false)
+	resp := testDoRaw(t, c, bufio.NewReader(c), "GET /a?b=1 HTTP/1.1\r\nHost: example.com:8443\r\n\r\n")
+	if resp.StatusCode() != StatusPermanentRedirect || !resp.ConnectionClose() {
+		t.Fatalf("unexpected response %d, Connection: close=%v", resp.StatusCode(), resp.ConnectionClose())
+	}
+	if loc := string(resp.Header.Peek("Location")); loc != "https://example.com:8443/a?b=1" {
+		t.Fatalf("unexpected Location %q", loc)
+	}
+
+	// 无Host时，无法重定向
+	c = dialTLSSniff(t, addr, false)
+	resp = testDoRaw(t, c, bufio.NewReader(c), "GET / HTTP/1.0\r\n\r\n")
+	if resp.StatusCode() != StatusBadRequest {
+		t.Fatalf("unexpected status code %d, want %d", resp.StatusCode(), StatusBadRequest)
+	}
+
+	c = dialTLSSniff(t, addr, true)
+	if resp := testDoRaw(t, c, bufio.NewReader(c), testGetRequest); string(resp.Body()) != "tls" {
+		t.Fatalf("unexpected body %q", resp.Body())
+	}
+}
+
+// 未发送数据的连接在TLSSniffTimeout后关闭,且不阻塞其它连接
+func TestServerTLSSniffTimeout(t *testing.T) {
+	addr := startTLSSniffServer(t, &Server{
+		TLSPlaintext:    TLSPlaintextServe,
+		TLSSniffTimeout: 100 * time.Millisecond,
+	})
+	idle := dialTLSSniff(t, addr, false)
+
+	c := dialTLSSniff(t, addr, true)
+	if resp := testDoRaw(t, c, bufio.NewReader(c), testGetRequest); string(resp.Body()) != "tls" {
+		t.Fatalf("unexpected body %q", resp.Body())
+	}
+
+	start := time.Now()
+	idle.SetReadDeadline(time.Now().Add(time.Second))
+	if n, err := idle.Read(make([]byte, 1)); err == nil {
+		t.Fatalf("unexpected data (%d bytes) on idle conn", n)
+	}
+	if d := time.Since(start); d >= time.Second {
+		t.Fatalf("idle conn was not closed by TLSSniffTimeout")
+	}
+}
@@ -0,0 +1,140 @@
+package selfFastHttp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// 检测残留socket文件的连接超时
+const staleUnixSocketDialTimeout = time.Second
+
+// 移除残留的socket文件
+// 文件不存在、或为无进程监听的socket文件时，返回nil
+func removeStaleUnixSocket(addr string) error {
+	fi, err := os.Lstat(addr)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("cannot stat unix socket file %q: %s", addr, err)
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("cannot listen on %q: the file exists and is not a unix socket", addr)
+	}
+
+	c, err := net.DialTimeout("unix", addr, staleUnixSocketDialTimeout)
+	if err == nil {
+		c.Close()
+		return fmt.Errorf("cannot listen on %q: the unix socket is in use by another process", addr)
+	}
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return fmt.Errorf("cannot check whether unix socket %q is in use: %s", addr, err)
+	}
+	if err = os.Remove(addr); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unexpected error when trying to remove unix socket file %q: %s", addr, err)
+	}
+	return nil
+}
+
+// 在addr所在目录下的私有临时目录(0700)中创建socket,设置mode、所有者后，再rename为addr
+// 避免socket文件在chmod、chown前，以umask决定的权限被其它用户连接
+// 返回的监听器关闭时，不删除socket文件，由调用者删除addr
+func listenUnixSocket(addr string, mode os.FileMode, owner, group string) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(addr), ".sock-")
+	if err != nil {
+		return nil, fmt.Errorf("cannot create temporary directory for unix socket %q: %s", addr, err)
+	}
+	defer os.RemoveAll(dir)
+
+	tmp := filepath.Join(dir, "s") // 尽量短:socket路径长度有限制
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmp, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	ln.SetUnlinkOnClose(false)
+	if err = os.Chmod(tmp, mode); err != nil {
+		err = fmt.Errorf("cannot chmod %#o for %q: %s", mode, addr, err)
+	} else if err = chownUnixSocket(tmp, owner, group); err == nil {
+		if err = os.Rename(tmp, addr); err != nil {
+			err = fmt.Errorf("cannot move unix socket to %q: %s", addr, err)
+		}
+	}
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// 设置socket文件的所有者、所属组
+// owner,group为空时，不修改
+func chownUnixSocket(addr, owner, group string) error {
+	if len(owner) == 0 && len(group) == 0 {
+		return nil
+	}
+	uid, gid := -1, -1
+	var err error
+	if len(owner) > 0 {
+		if uid, err = lookupUID(owner); err != nil {
+			return err
+		}
+	}
+	if len(group) > 0 {
+		if gid, err = lookupGID(group); err != nil {
+			return err
+		}
+	}
+	if err = os.Chown(addr, uid, gid); err != nil {
+		return fmt.Errorf("cannot chown %q to %q:%q: %s", addr, owner, group, err)
+	}
+	return nil
+}
+
+func lookupUID(owner string) (int, error) {
+	if uid, err := strconv.Atoi(owner); err == nil {
+		return uid, nil
+	}
+	u, err := user.Lookup(owner)
+	if err != nil {
+		return -1, fmt.Errorf("cannot find unix socket owner %q: %s", owner, err)
+	}
+	return strconv.Atoi(u.Uid)
+}
+
+func lookupGID(group string) (int, error) {
+	if gid, err := strconv.Atoi(group); err == nil {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return -1, fmt.Errorf("cannot find unix socket group %q: %s", group, err)
+	}
+	return strconv.Atoi(g.Gid)
+}
+
+// unix socket对端进程的凭证(SO_PEERCRED)
+// 为对端connect时的值
+type UnixPeerCred struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
+
+var errNotUnixConn = errors.New("not a unix socket connection")
+
+// 返回unix socket连接对端进程的凭证,可用于按uid授权本机调用者
+// 非unix socket连接，返回错误;仅支持linux
+func (ctx *RequestCtx) UnixPeerCred() (*UnixPeerCred, error) {
+	uc, ok := unwrapConn(ctx.c, nil).(*net.UnixConn)
+	if !ok {
+		return nil, errNotUnixConn
+	}
+	return getUnixPeerCred(uc)
+}
@@ -0,0 +1,30 @@
+//go:build linux
+// +build linux
+
+package selfFastHttp
+
+import (
+	"net"
+	"syscall"
+)
+
+func getUnixPeerCred(c *net.UnixConn) (*UnixPeerCred, error) {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var cred *syscall.Ucred
+	if cerr := rc.Control(func(fd uintptr) {
+		cred, err = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); cerr != nil {
+		return nil, cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &UnixPeerCred{
+		PID: cred.Pid,
+		UID: cred.Uid,
+		GID: cred.Gid,
+	}, nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package selfFastHttp
+
+import (
+	"errors"
+	"net"
+)
+
+var errNoPeerCred = errors.New("SO_PEERCRED is not supported on this platform")
+
+func getUnixPeerCred(c *net.UnixConn) (*UnixPeerCred, error) {
+	return nil, errNoPeerCred
+}
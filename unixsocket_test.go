@@ -0,0 +1,76 @@
+//go:build !windows
+// +build !windows
+
+package selfFastHttp
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestServerListenAndServeUNIX(t *testing.T) {
+	dir := t.TempDir()
+	addr := filepath.Join(dir, "a.sock")
+	s := &Server{
+		Handler: func(ctx *RequestCtx) { ctx.WriteString("ok") },
+		Logger:  &testLogger{},
+	}
+	serveCh := make(chan error, 1)
+	go func() { serveCh <- s.ListenAndServeUNIX(addr, 0600) }()
+
+	var fi os.FileInfo
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		var err error
+		if fi, err = os.Stat(addr); err == nil {
+			break
+		}
+		if time.Since(start) > time.Second {
+			t.Fatalf("timeout waiting for %q: %v", addr, err)
+		}
+	}
+	// 出现在addr时，权限已设置
+	if fi.Mode()&os.ModeSocket == 0 || fi.Mode().Perm() != 0600 {
+		t.Fatalf("unexpected mode %s", fi.Mode())
+	}
+	// 临时目录已删除
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 1 {
+		t.Fatalf("unexpected entries %v in %q: %v", entries, dir, err)
+	}
+
+	c, err := net.Dial("unix", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if resp := testDoRaw(t, c, bufio.NewReader(c), testGetRequest); string(resp.Body()) != "ok" {
+		t.Fatalf("unexpected body %q", resp.Body())
+	}
+	c.Close()
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := <-serveCh; err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := os.Stat(addr); !os.IsNotExist(err) {
+		t.Fatalf("socket file is not removed: %v", err)
+	}
+}
+
+// 创建失败时，不残留临时目录
+func TestServerListenAndServeUNIXError(t *testing.T) {
+	dir := t.TempDir()
+	s := &Server{Handler: func(ctx *RequestCtx) {}, UnixSocketOwner: "no-such-user-selffasthttp"}
+	if err := s.ListenAndServeUNIX(filepath.Join(dir, "a.sock"), 0600); err == nil {
+		t.Fatal("expecting error for unknown owner")
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Fatalf("unexpected entries %v in %q: %v", entries, dir, err)
+	}
+}
@@ -278,6 +278,9 @@ func splitHostURI(host, uri []byte) ([]byte, []byte, []byte) {
 	if bytes.IndexByte(scheme, '/') >= 0 {
 		return strHTTP, host, uri
 	}
+	if len(scheme) > 0 && scheme[len(scheme)-1] == ':' { // 'http:'
+		scheme = scheme[:len(scheme)-1]
+	}
 	//--------------
 
 	n += len(strSlashSlash)
@@ -420,18 +423,19 @@ func (u *URI) updateBytes(newURI, buf []byte) []byte {
 		return buf
 	}
 
-	n := bytes.Index(newURI, strSlashSlash)
-	if n >= 0 {
-		//取出原scheme，若有值，而传入newURI不含scheme，则用之
-		var b [32]byte
-		schemeOriginal := b[:0]
-		if len(u.scheme) > 0 {
-			schemeOriginal = append([]byte(nil), u.scheme...) //新建并复制一份scheme
-		}
+	// i.e. //xxx.com/foo，沿用原scheme
+	if bytes.HasPrefix(newURI, strSlashSlash) {
+		buf = append(buf[:0], u.Scheme()...)
+		buf = append(buf, ':')
+		buf = append(buf, newURI...)
+		u.Parse(nil, buf)
+		return buf
+	}
+
+	// i.e. http://xxx.com/foo
+	// 仅以scheme://开头时视为绝对地址，/foo?next=http://xxx.com仍为相对地址
+	if hasURIScheme(newURI) {
 		u.Parse(nil, newURI)
-		if len(schemeOriginal) > 0 && len(u.scheme) == 0 {
-			u.scheme = append(u.scheme[:0], schemeOriginal...)
-		}
 		return buf
 	}
 
@@ -453,7 +457,7 @@ func (u *URI) updateBytes(newURI, buf []byte) []byte {
 		return append(buf[:0], u.FullURI()...)
 	default:
 		path := u.Path()
-		n = bytes.LastIndexByte(path, '/')
+		n := bytes.LastIndexByte(path, '/')
 		if n < 0 {
 			panic("BUG: path must contain at least one slash")
 		}
@@ -465,6 +469,22 @@ func (u *URI) updateBytes(newURI, buf []byte) []byte {
 	}
 }
 
+// b是否以合法的scheme://开头
+// scheme = ALPHA *( ALPHA / DIGIT / "+" / "-" / "." )
+func hasURIScheme(b []byte) bool {
+	for i, c := range b {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case i > 0 && (c >= '0' && c <= '9' || c == '+' || c == '-' || c == '.'):
+		case i > 0 && c == ':':
+			return bytes.HasPrefix(b[i+1:], strSlashSlash)
+		default:
+			return false
+		}
+	}
+	return false
+}
+
 // 返回: {Scheme}://{Host}{RequestURI}#{Fragment}
 func (u *URI) FullURI() []byte {
 	u.fullURI = u.AppendBytes(u.fullURI[:0])
@@ -0,0 +1,50 @@
+package selfFastHttp
+
+import "testing"
+
+func TestURIParseScheme(t *testing.T) {
+	for _, tc := range []struct {
+		host, uri              string
+		scheme, wantHost, path string
+	}{
+		{"", "http://foo.com/bar", "http", "foo.com", "/bar"},
+		{"", "https://foo.com/bar?x=1", "https", "foo.com", "/bar"},
+		{"", "HTTPS://foo.com", "https", "foo.com", "/"},
+		{"aaa.com", "//foo.com/bar", "http", "foo.com", "/bar"},
+		{"aaa.com", "/bar", "http", "aaa.com", "/bar"},
+	} {
+		var u URI
+		u.Parse([]byte(tc.host), []byte(tc.uri))
+		if string(u.Scheme()) != tc.scheme {
+			t.Errorf("Parse(%q, %q): scheme %q, want %q", tc.host, tc.uri, u.Scheme(), tc.scheme)
+		}
+		if string(u.Host()) != tc.wantHost {
+			t.Errorf("Parse(%q, %q): host %q, want %q", tc.host, tc.uri, u.Host(), tc.wantHost)
+		}
+		if string(u.Path()) != tc.path {
+			t.Errorf("Parse(%q, %q): path %q, want %q", tc.host, tc.uri, u.Path(), tc.path)
+		}
+	}
+}
+
+func TestURIUpdate(t *testing.T) {
+	for _, tc := range []struct {
+		base, update string
+		want         string
+	}{
+		{"http://foo.com/a/b", "/c?next=http://bar.com/", "http://foo.com/c?next=http://bar.com/"},
+		{"http://foo.com/a/b", "c?x=1", "http://foo.com/a/c?x=1"},
+		{"http://foo.com/a/b", "?x=1", "http://foo.com/a/b?x=1"},
+		{"http://foo.com/a/b", "c/d//e", "http://foo.com/a/c/d/e"},
+		{"http://foo.com/a/b", "https://bar.com/c", "https://bar.com/c"},
+		{"https://foo.com/a/b", "//bar.com/c", "https://bar.com/c"},
+		{"http://foo.com/a/b", "//bar.com/c", "http://bar.com/c"},
+	} {
+		var u URI
+		u.Parse(nil, []byte(tc.base))
+		u.Update(tc.update)
+		if got := string(u.FullURI()); got != tc.want {
+			t.Errorf("Update(%q, %q): %q, want %q", tc.base, tc.update, got, tc.want)
+		}
+	}
+}
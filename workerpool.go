@@ -31,6 +31,8 @@ type workerPool struct {
 
 	stopCh chan struct{}
 
+	freeCh chan struct{} // 有worker闲置时通知,用于等待空闲worker
+
 	workerChanPool sync.Pool
 }
 
@@ -44,6 +46,15 @@ type workerChan struct {
 //	return wp.workersCount
 //}
 
+// 返回当前工作协程数、闲置协程数
+func (wp *workerPool) stats() (workers, idle int) {
+	wp.lock.Lock()
+	workers = wp.workersCount
+	idle = len(wp.ready)
+	wp.lock.Unlock()
+	return workers, idle
+}
+
 //开始运行
 //启用协程，定时处理闲置chan
 func (wp *workerPool) Start() {
@@ -55,6 +66,7 @@ func (wp *workerPool) Start() {
 	}
 	wp.stopCh = make(chan struct{})
 	stopCh := wp.stopCh
+	wp.freeCh = make(chan struct{}, 1)
 
 	//1.清理闲置超时的chan
 	//2.受stopCh控制
@@ -71,15 +83,6 @@ func (wp *workerPool) Start() {
 			}
 		}
 	}()
-	go func() {
-		t := time.NewTicker(time.Second)
-		for {
-			select {
-			case <-t.C:
-				wp.Logger.Printf("workersCount:%d", wp.workersCount)
-			}
-		}
-	}()
 }
 
 //1.添加停止标志
@@ -151,6 +154,25 @@ func (wp *workerPool) Serve(c net.Conn) bool {
 	return true
 }
 
+// 等待有worker闲置,最多等到timer触发
+// 有闲置通知返回true,超时返回false
+func (wp *workerPool) waitFree(t *time.Timer) bool {
+	select {
+	case <-wp.freeCh:
+		return true
+	case <-t.C:
+		return false
+	}
+}
+
+// 通知等待者:有worker闲置
+func (wp *workerPool) notifyFree() {
+	select {
+	case wp.freeCh <- struct{}{}:
+	default:
+	}
+}
+
 var workerChanCap = func() int {
 	//据说明：单核阻塞性能更好 go1.5
 	if runtime.GOMAXPROCS(0) == 1 {
@@ -212,6 +234,7 @@ func (wp *workerPool) release(ch *workerChan) bool {
 	}
 	wp.ready = append(wp.ready, ch)
 	wp.lock.Unlock()
+	wp.notifyFree()
 	return true
 }
 
@@ -245,4 +268,5 @@ func (wp *workerPool) workerFunc(ch *workerChan) {
 	wp.lock.Lock()
 	wp.workersCount--
 	wp.lock.Unlock()
+	wp.notifyFree()
 }